@@ -0,0 +1,162 @@
+package main
+
+import (
+	"cloak/pkg/audit"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// AuditLogFile is the default audit log in the output directory
+const AuditLogFile = "audit.jsonl"
+
+// Audit log events
+const (
+	AuditRunStart  = "run.start"
+	AuditRunFinish = "run.finish"
+)
+
+// AuditRecord is the data of a run event in the audit log
+type AuditRecord struct {
+	Run            string          `json:"run"`
+	Operator       string          `json:"operator"`
+	Host           string          `json:"host"`
+	Engagement     string          `json:"engagement,omitempty"`
+	Profile        string          `json:"profile,omitempty"`
+	ProfileSHA256  string          `json:"profile_sha256,omitempty"`
+	Target         string          `json:"target"`
+	RepoURL        string          `json:"repo_url"`
+	Commit         string          `json:"commit,omitempty"`
+	Modules        []string        `json:"modules"`
+	Artifacts      []AuditArtifact `json:"artifacts,omitempty"`
+	Status         string          `json:"status"`
+	Error          string          `json:"error,omitempty"`
+	ReproducedFrom string          `json:"reproduced_from,omitempty"`
+}
+
+// AuditArtifact identifies an artifact in the audit log
+type AuditArtifact struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// auditLogFlag adds the -audit-log flag. An empty value means the default
+// log in the output directory, see auditLogPath.
+func auditLogFlag(fs *flag.FlagSet) *string {
+	return fs.String("audit-log", os.Getenv("CLOAK_AUDIT_LOG"), "Audit log every run is recorded in (env CLOAK_AUDIT_LOG, default <output>/"+AuditLogFile+")")
+}
+
+// auditLogPath returns the audit log for an -audit-log value
+func auditLogPath(value, outputDir string) string {
+	if value != "" {
+		return value
+	}
+	return filepath.Join(outputDir, AuditLogFile)
+}
+
+// operatorName returns the operator given for the run, or the OS user
+func operatorName(config *Config) string {
+	if config.Operator != "" {
+		return config.Operator
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// audit appends a run event to the audit log, if one is configured
+func (b *Builder) audit(event string) error {
+	if b.config.AuditLog == "" {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	record := AuditRecord{
+		Run:            b.meta.ID,
+		Operator:       operatorName(b.config),
+		Host:           host,
+		Engagement:     b.config.Engagement,
+		Profile:        b.config.Flags["profile"],
+		Target:         b.meta.Target,
+		RepoURL:        b.meta.RepoURL,
+		Commit:         b.meta.Commit,
+		Modules:        b.meta.Modules,
+		Status:         b.meta.Status,
+		Error:          b.meta.Error,
+		ReproducedFrom: b.meta.ReproducedFrom,
+	}
+	if record.Profile != "" {
+		if _, sum, err := hashFile(record.Profile); err == nil {
+			record.ProfileSHA256 = sum
+		}
+	}
+	for _, artifact := range b.meta.Artifacts {
+		record.Artifacts = append(record.Artifacts, AuditArtifact{Name: artifact.Name, SHA256: artifact.SHA256})
+	}
+
+	if _, err := audit.Open(b.config.AuditLog).Append(event, record); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// auditCommand implements 'cloak audit verify'
+func auditCommand(args []string) error {
+	const synopsis = "verify [-log file] [-json]"
+	if len(args) > 0 && isHelpFlag(args[0]) {
+		fmt.Fprintf(os.Stderr, "usage: cloak audit <command> [flags]\n\ncommands:\n  verify  check the audit log for modified, missing or reordered entries\n")
+		return nil
+	}
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("usage: cloak audit " + synopsis)
+	}
+
+	fs := newFlagSet("audit verify", "[flags]", "Check the audit log for modified, missing or reordered entries.")
+	outputDir := outputFlag(fs)
+	logPath := fs.String("log", os.Getenv("CLOAK_AUDIT_LOG"), "Audit log (env CLOAK_AUDIT_LOG, default <output>/"+AuditLogFile+")")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if _, err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	path := auditLogPath(*logPath, *outputDir)
+	report, err := audit.Verify(path)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEQ\tTIME\tEVENT\tRUN\tOPERATOR\tSTATUS")
+		for _, entry := range report.Entries {
+			var record AuditRecord
+			json.Unmarshal(entry.Data, &record)
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", entry.Seq, entry.Time.Format("2006-01-02 15:04:05"),
+				entry.Event, record.Run, record.Operator, record.Status)
+		}
+		w.Flush()
+		for _, problem := range report.Problems {
+			fmt.Println("problem:", problem)
+		}
+	}
+
+	if !report.OK() {
+		return fmt.Errorf("audit log %s failed verification with %d problems", path, len(report.Problems))
+	}
+	if !*asJSON {
+		fmt.Printf("%s: %d entries, chain intact\n", path, len(report.Entries))
+	}
+	return nil
+}
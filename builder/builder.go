@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"log"
+	"path/filepath"
+	"time"
 )
 
 // Module defines the interface for build modules.
@@ -14,12 +16,22 @@ type Module interface {
 	Run(config *Config, verbose bool) error
 }
 
+// Describer is optionally implemented by modules to describe what they do
+// in 'cloak modules list'
+type Describer interface {
+	Description() string
+}
+
 // Builder orchestrates the build process by managing a collection of modules.
 // It provides a centralized way to configure and execute multiple build steps
 type Builder struct {
 	modules map[string]Module
+	order   []string // Module names in registration order
 	config  *Config
 	verbose bool // Controls command output display
+	meta    *RunMeta
+
+	reproduces string // ID of the run being rebuilt by verify repro
 }
 
 // NewBuilder creates a new Builder instance with the provided configuration.
@@ -42,21 +54,38 @@ func NewBuilder(config *Config, verbose bool) *Builder {
 // RegisterModule adds a new module to the builder's registry.
 // Each module is stored in the modules map using its name as the key.
 // If a module with the same name already exists, it will be overwritten.
+// Modules selected with "all" run in the order they were registered.
 //
 // Parameters:
 //   - m: The module to register, must implement the Module interface
 func (b *Builder) RegisterModule(m Module) {
+	if _, exists := b.modules[m.Name()]; !exists {
+		b.order = append(b.order, m.Name())
+	}
 	b.modules[m.Name()] = m
 }
 
+// Modules returns the registered modules in registration order
+func (b *Builder) Modules() []Module {
+	modules := make([]Module, 0, len(b.order))
+	for _, name := range b.order {
+		modules = append(modules, b.modules[name])
+	}
+	return modules
+}
+
 // Run executes the complete build process in three main steps:
 // 1. Clones the repository
 // 2. Runs specified modules (if any)
 // 3. Executes make commands for compilation
 //
+// Selected modules that implement the optional hook interfaces (see
+// hooks.go) are also called before and after cloning and building.
+//
 // Parameters:
-//   - moduleNames: Slice of module names to execute. If empty or ["all"],
-//     only repo cloning and compilation will be performed
+//   - moduleNames: Slice of module names to execute. If ["all"], every
+//     registered module runs in registration order. If empty, only repo
+//     cloning and compilation will be performed
 //
 // Returns:
 //   - error: If any step in the build process fails
@@ -65,37 +94,143 @@ func (b *Builder) RegisterModule(m Module) {
 // - First, the repository is always cloned
 // - Then, if specific modules are requested, they are executed in order
 // - Finally, make commands are run to compile the project
-func (b *Builder) Run(moduleNames []string) error {
+//
+// Progress and outcome are recorded in run.json in the run directory.
+func (b *Builder) Run(moduleNames []string) (err error) {
+	if len(moduleNames) > 0 && moduleNames[0] == "all" {
+		moduleNames = append([]string(nil), b.order...)
+	}
+
+	b.meta = &RunMeta{
+		ID:        filepath.Base(b.config.RunDir),
+		Target:    b.config.Target.Tag,
+		GitRef:    b.config.Target.GitRef,
+		RepoURL:   b.config.RepoURL,
+		Modules:   moduleNames,
+		Toolchain: b.config.Target.Toolchain,
+		Flags:     b.config.Flags,
+		Params:    b.config.ModuleParams,
+		Seed:      b.config.Seed,
+		StartedAt: time.Now().UTC(),
+		Status:    RunStatusRunning,
+		dir:       b.config.RunDir,
+
+		ReproducedFrom: b.reproduces,
+	}
+	if !b.config.BuildVars.IsZero() {
+		b.meta.BuildVars = &b.config.BuildVars
+	}
+	if err := writeRunMeta(b.meta); err != nil {
+		return err
+	}
+	// From here on run.json always ends with the outcome, even when the
+	// start entry cannot be audited
+	started := false
+	defer func() {
+		finished := time.Now().UTC()
+		b.meta.FinishedAt = &finished
+		b.meta.Status = RunStatusSuccess
+		if err != nil {
+			b.meta.Status = RunStatusFailed
+			b.meta.Error = err.Error()
+		}
+		if werr := writeRunMeta(b.meta); werr != nil && err == nil {
+			err = werr
+		}
+		if !started {
+			return
+		}
+		if aerr := b.audit(AuditRunFinish); aerr != nil && err == nil {
+			err = aerr
+		}
+	}()
+	// A run that cannot be audited does not start
+	if err := b.audit(AuditRunStart); err != nil {
+		return err
+	}
+	started = true
+
+	// Catch unknown modules and parameters before spending time on a clone
+	if err := b.validateModules(moduleNames); err != nil {
+		return err
+	}
+
+	if err := b.preClone(moduleNames); err != nil {
+		return err
+	}
+
 	log.Println("Cloning Sliver...")
 	if err := b.cloneRepo(); err != nil {
 		return fmt.Errorf("clone failed: %w", err)
 	}
 
+	commit, err := b.resolveCommit()
+	if err != nil {
+		return err
+	}
+	b.meta.Commit = commit
+	b.config.Commit = commit
+	if err := writeRunMeta(b.meta); err != nil {
+		return err
+	}
+
+	if err := b.setupToolchain(); err != nil {
+		return fmt.Errorf("toolchain check failed: %w", err)
+	}
+
+	moduleNames, err = b.filterCompatible(moduleNames)
+	if err != nil {
+		return err
+	}
+
+	if err := b.postClone(moduleNames); err != nil {
+		return err
+	}
+
 	// Handle module execution
 	if len(moduleNames) > 0 {
-		if moduleNames[0] == "all" {
-			// Get all registered module names
-			allModules := make([]string, 0, len(b.modules))
-			for name := range b.modules {
-				allModules = append(allModules, name)
-			}
-			if err := b.runModules(allModules); err != nil {
-				return err
-			}
-		} else {
-			if err := b.runModules(moduleNames); err != nil {
-				return err
-			}
+		if err := b.runModules(moduleNames); err != nil {
+			return err
 		}
 	}
 
+	if err := b.embedProvenance(); err != nil {
+		return err
+	}
+
+	// Remember what was in the tree so new build output can be identified
+	before, err := snapshotFiles(filepath.Join(b.config.RunDir, "sliver"))
+	if err != nil {
+		return fmt.Errorf("failed to list source tree: %w", err)
+	}
+
+	if err := b.preBuild(moduleNames); err != nil {
+		return err
+	}
+
 	// Run make commands
 	log.Println("Compiling...")
 	if err := b.runMake(); err != nil {
 		return err
 	}
 
-	return nil
+	artifacts, err := b.collectArtifacts(before)
+	if err != nil {
+		return err
+	}
+	artifacts, err = b.postBuild(moduleNames, artifacts)
+	if err != nil {
+		return err
+	}
+	b.meta.Artifacts = artifacts
+	for _, artifact := range artifacts {
+		log.Printf("Artifact: %s (sha256 %s)", artifact.Path, artifact.SHA256)
+	}
+
+	if err := b.smokeTest(moduleNames, artifacts); err != nil {
+		return err
+	}
+	return b.checkResiduals(moduleNames, artifacts)
 }
 
 // runModules executes a sequence of modules in the order specified.
@@ -110,8 +245,17 @@ func (b *Builder) Run(moduleNames []string) error {
 // The function will stop execution and return an error immediately if:
 //   - A requested module is not found in the registry
 //   - Any module's Run() method returns an error
+//
+// The source tree is snapshotted around every module so the changes each
+// module made are recorded as a patch in the run directory. When a module
+// fails, its changes are rolled back to the previous snapshot.
 func (b *Builder) runModules(moduleNames []string) error {
-	for _, name := range moduleNames {
+	tree, err := b.gitSnapshot()
+	if err != nil {
+		return err
+	}
+
+	for i, name := range moduleNames {
 		module, exists := b.modules[name]
 		if !exists {
 			return fmt.Errorf("module %s not found", name)
@@ -119,8 +263,39 @@ func (b *Builder) runModules(moduleNames []string) error {
 
 		log.Println("Running module:", module.Name())
 		if err := module.Run(b.config, b.verbose); err != nil {
+			// Leave the tree as it was before the failed module
+			if rerr := b.gitRestore(tree); rerr != nil {
+				log.Printf("Warning: failed to roll back module %s: %v", name, rerr)
+			} else {
+				log.Printf("Rolled back changes of module %s", name)
+				b.meta.RolledBack = name
+			}
 			return fmt.Errorf("module %s failed: %w", name, err)
 		}
+
+		next, err := b.gitSnapshot()
+		if err != nil {
+			return err
+		}
+		change, err := b.recordChange(i+1, name, tree, next)
+		if err != nil {
+			return err
+		}
+		b.meta.Changes = append(b.meta.Changes, change)
+		if r, ok := module.(Renamer); ok {
+			if b.meta.Renames == nil {
+				b.meta.Renames = make(map[string][]Replacement)
+			}
+			b.meta.Renames[name] = r.Replacements()
+			b.config.Renames = append(b.config.Renames, r.Replacements()...)
+		}
+		if err := writeRunMeta(b.meta); err != nil {
+			return err
+		}
+		if b.verbose {
+			log.Printf("Module %s changed %d files", name, len(change.Files))
+		}
+		tree = next
 	}
 	return nil
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunFailsWhenAuditLogUnwritable(t *testing.T) {
+	outputDir := t.TempDir()
+	config, err := NewConfig("1.5", outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A regular file where the audit log's directory should be
+	notDir := filepath.Join(outputDir, "not-a-dir")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.AuditLog = filepath.Join(notDir, AuditLogFile)
+
+	if err := NewBuilder(config, false).Run(nil); err == nil {
+		t.Fatal("run started without an audit entry")
+	}
+	meta, err := readRunMeta(config.RunDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Status != RunStatusFailed || meta.FinishedAt == nil || meta.Error == "" {
+		t.Errorf("run.json has status %s, finished %v, error %q, want failed", meta.Status, meta.FinishedAt, meta.Error)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ldVarName   = regexp.MustCompile(`^[^\s='"]+\.[A-Za-z_][A-Za-z0-9_]*$`) // importpath.name
+	envName     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	buildTag    = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+	shellSimple = regexp.MustCompile(`^[A-Za-z0-9_./:,=+@%-]+$`)
+)
+
+// BuildVars are overrides for the make step, given in the profile's make
+// section and on the command line
+type BuildVars struct {
+	X       map[string]string `json:"x,omitempty" yaml:"x,omitempty"`             // importpath.name -> value, set with -X
+	Tags    []string          `json:"tags,omitempty" yaml:"tags,omitempty"`       // added to the Makefile's TAGS
+	GoFlags string            `json:"goflags,omitempty" yaml:"goflags,omitempty"` // added to GOFLAGS
+	Env     map[string]string `json:"env,omitempty" yaml:"env,omitempty"`         // environment for make
+}
+
+// IsZero reports whether no override is set
+func (v *BuildVars) IsZero() bool {
+	return v == nil || len(v.X) == 0 && len(v.Tags) == 0 && v.GoFlags == "" && len(v.Env) == 0
+}
+
+// Validate checks variable names, tags and environment names
+func (v *BuildVars) Validate() error {
+	for name, value := range v.X {
+		if !ldVarName.MatchString(name) {
+			return fmt.Errorf("invalid -X variable %q, expected importpath.name", name)
+		}
+		if strings.Contains(value, "'") && strings.Contains(value, `"`) {
+			return fmt.Errorf("-X value for %s cannot hold both ' and \"", name)
+		}
+	}
+	for _, tag := range v.Tags {
+		if !buildTag.MatchString(tag) {
+			return fmt.Errorf("invalid build tag %q", tag)
+		}
+	}
+	for name := range v.Env {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// setPair parses a name=value pair into m, creating it if needed
+func setPair(m *map[string]string, pair, what string) error {
+	key, value, found := strings.Cut(pair, "=")
+	if !found || key == "" {
+		return fmt.Errorf("invalid %s %q, expected name=value", what, pair)
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[key] = value
+	return nil
+}
+
+// ldflags returns the -X flags for the linker, sorted by variable. Values
+// are quoted for the go command's flag parsing where needed.
+func (v *BuildVars) ldflags() []string {
+	names := make([]string, 0, len(v.X))
+	for name := range v.X {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var flags []string
+	for _, name := range names {
+		assignment := name + "=" + v.X[name]
+		switch {
+		case !strings.ContainsAny(assignment, " \t\n'\""):
+		case !strings.Contains(assignment, "'"):
+			assignment = "'" + assignment + "'"
+		default:
+			assignment = `"` + assignment + `"`
+		}
+		flags = append(flags, "-X", assignment)
+	}
+	return flags
+}
+
+// environ applies the environment and GOFLAGS overrides to env
+func (v *BuildVars) environ(env []string) []string {
+	names := make([]string, 0, len(v.Env))
+	for name := range v.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = setEnv(env, name, v.Env[name])
+	}
+	if v.GoFlags != "" {
+		env = setEnv(env, "GOFLAGS", strings.TrimSpace(getEnv(env, "GOFLAGS")+" "+v.GoFlags))
+	}
+	return env
+}
+
+// appendTags returns a make variable assignment that replaces the
+// Makefile's TAGS (of the form -tags a,b) with one that has tags appended
+func (b *Builder) appendTags(makeDir string, env, vars, tags []string) (string, error) {
+	value, err := b.makeVariable(makeDir, "TAGS", env, vars)
+	if err != nil {
+		return "", err
+	}
+
+	words, err := splitShellWords(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse TAGS: %w", err)
+	}
+	var existing string
+	switch {
+	case len(words) == 0:
+	case len(words) == 2 && words[0] == "-tags":
+		existing = words[1]
+	case len(words) == 1 && strings.HasPrefix(words[0], "-tags="):
+		existing = strings.TrimPrefix(words[0], "-tags=")
+	default:
+		return "", fmt.Errorf("unsupported TAGS format: %s", value)
+	}
+	list := strings.Join(tags, ",")
+	if existing != "" {
+		list = existing + "," + list
+	}
+	return "TAGS=-tags " + list, nil
+}
+
+// commandLine formats a make invocation as a shell command, prefixed with
+// the environment that differs from cloak's own
+func commandLine(env []string, args []string) string {
+	var words []string
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		if current, set := os.LookupEnv(key); !set || current != value {
+			words = append(words, key+"="+shellQuote(value))
+		}
+	}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes s for a POSIX shell if it needs quoting
+func shellQuote(s string) string {
+	if shellSimple.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildVarsLDFlags(t *testing.T) {
+	v := BuildVars{X: map[string]string{
+		"main.Version":   "v1.5.99",
+		"main.GoVersion": "go version go1.21 linux/amd64",
+		"main.Quote":     "it's",
+	}}
+	want := []string{
+		"-X", "'main.GoVersion=go version go1.21 linux/amd64'",
+		"-X", `"main.Quote=it's"`,
+		"-X", "main.Version=v1.5.99",
+	}
+	if got := v.ldflags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ldflags() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildVarsValidate(t *testing.T) {
+	for _, v := range []BuildVars{
+		{X: map[string]string{"Version": "1"}},
+		{X: map[string]string{"main.Version=1": "1"}},
+		{X: map[string]string{"main.Version": `it's "quoted"`}},
+		{Tags: []string{"netgo", ""}},
+		{Tags: []string{"a b"}},
+		{Env: map[string]string{"1X": "1"}},
+	} {
+		if err := v.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", v)
+		}
+	}
+	v := BuildVars{
+		X:    map[string]string{"github.com/bishopfox/sliver/client/version.Version": "v1.5.99"},
+		Tags: []string{"osusergo", "netgo"},
+		Env:  map[string]string{"CGO_ENABLED": "0"},
+	}
+	if err := v.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildVarsEnviron(t *testing.T) {
+	v := BuildVars{GoFlags: "-mod=vendor", Env: map[string]string{"CGO_ENABLED": "0"}}
+	env := v.environ([]string{"GOFLAGS=-trimpath", "CGO_ENABLED=1"})
+	if got := getEnv(env, "GOFLAGS"); got != "-trimpath -mod=vendor" {
+		t.Errorf("GOFLAGS = %q", got)
+	}
+	if got := getEnv(env, "CGO_ENABLED"); got != "0" {
+		t.Errorf("CGO_ENABLED = %q", got)
+	}
+}
+
+func TestAppendTags(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+	for makefile, want := range map[string]string{
+		"TAGS = -tags osusergo,netgo\n": "TAGS=-tags osusergo,netgo,extra",
+		"TAGS = -tags=osusergo\n":       "TAGS=-tags osusergo,extra",
+		"all:\n":                        "TAGS=-tags extra",
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0644); err != nil {
+			t.Fatal(err)
+		}
+		b := &Builder{config: &Config{RunDir: dir}}
+		got, err := b.appendTags(dir, os.Environ(), nil, []string{"extra"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("appendTags with %q = %q, want %q", strings.TrimSpace(makefile), got, want)
+		}
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	env := append(os.Environ(), "CLOAK_TEST_VAR=a b")
+	got := commandLine(env, []string{"make", "default", `LDFLAGS=-ldflags "-s -w"`})
+	want := `CLOAK_TEST_VAR='a b' make default 'LDFLAGS=-ldflags "-s -w"'`
+	if got != want {
+		t.Errorf("commandLine = %s, want %s", got, want)
+	}
+	if got := shellQuote("it's"); got != `'it'\''s'` {
+		t.Errorf("shellQuote = %s", got)
+	}
+}
+
+func TestProfileMakeOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := "make:\n  targets: [default]\n  x:\n    main.Version: v1\n    main.Name: base\n  tags: [netgo]\n  env:\n    CGO_ENABLED: \"0\"\n"
+	top := "extends: base.yaml\nmake:\n  x:\n    main.Version: v2\n  goflags: -mod=vendor\n"
+	for name, data := range map[string]string{"base.yaml": base, "top.yaml": top} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profile, err := LoadProfile(filepath.Join(dir, "top.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BuildVars{
+		X:       map[string]string{"main.Version": "v2", "main.Name": "base"},
+		Tags:    []string{"netgo"},
+		GoFlags: "-mod=vendor",
+		Env:     map[string]string{"CGO_ENABLED": "0"},
+	}
+	if !reflect.DeepEqual(profile.Make.Vars, want) {
+		t.Errorf("merged make vars = %+v, want %+v", profile.Make.Vars, want)
+	}
+	if flags := profile.flagValues(); flags["tags"] != "netgo" || flags["goflags"] != "-mod=vendor" {
+		t.Errorf("flag values = %v", flags)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ChangesDir is the directory inside a run that holds per-module patches
+const ChangesDir = "changes"
+
+// FileChange is a single entry of git's --name-status output
+type FileChange struct {
+	Status  string `json:"status"` // A, M, D or R
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"` // set for renames
+}
+
+// ModuleChange records what a single module did to the source tree
+type ModuleChange struct {
+	Module string       `json:"module"`
+	Patch  string       `json:"patch"` // relative to the run directory
+	Tree   string       `json:"tree"`  // git tree hash after the module ran
+	Files  []FileChange `json:"files,omitempty"`
+}
+
+// gitSnapshot records the current state of the working tree (including
+// untracked files) as a git tree object and returns its hash. A private index
+// file is used so the clone's own index is left untouched.
+func (b *Builder) gitSnapshot() (string, error) {
+	repoDir := filepath.Join(b.config.RunDir, "sliver")
+	env := append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(b.config.RunDir, ".cloak-index"))
+
+	cmd := exec.Command("git", "add", "-A", ".")
+	cmd.Dir = repoDir
+	cmd.Env = env
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to stage tree: %w: %s", err, output)
+	}
+
+	cmd = exec.Command("git", "write-tree")
+	cmd.Dir = repoDir
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to write tree: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// gitRestore resets the working tree to a snapshot taken by gitSnapshot.
+// Files created since are removed unless they are ignored by .gitignore.
+func (b *Builder) gitRestore(tree string) error {
+	repoDir := filepath.Join(b.config.RunDir, "sliver")
+	env := append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(b.config.RunDir, ".cloak-index"))
+
+	// Stage the current state first so read-tree knows which files to remove
+	for _, args := range [][]string{
+		{"add", "-A", "."},
+		{"read-tree", "--reset", "-u", tree},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		cmd.Env = env
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %w: %s", args[0], err, output)
+		}
+	}
+	return nil
+}
+
+// recordChange diffs two snapshots, stores the patch under the run's changes
+// directory and returns the resulting record
+func (b *Builder) recordChange(index int, module, fromTree, toTree string) (ModuleChange, error) {
+	repoDir := filepath.Join(b.config.RunDir, "sliver")
+	change := ModuleChange{
+		Module: module,
+		Patch:  filepath.Join(ChangesDir, fmt.Sprintf("%02d-%s.patch", index, module)),
+		Tree:   toTree,
+	}
+
+	cmd := exec.Command("git", "diff", "--binary", "-M", fromTree, toTree)
+	cmd.Dir = repoDir
+	patch, err := cmd.Output()
+	if err != nil {
+		return change, fmt.Errorf("failed to diff module %s changes: %w", module, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(b.config.RunDir, ChangesDir), 0755); err != nil {
+		return change, fmt.Errorf("failed to create changes directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(b.config.RunDir, change.Patch), patch, 0644); err != nil {
+		return change, fmt.Errorf("failed to write module %s patch: %w", module, err)
+	}
+
+	cmd = exec.Command("git", "diff", "--name-status", "-M", fromTree, toTree)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return change, fmt.Errorf("failed to list module %s changes: %w", module, err)
+	}
+	change.Files = parseNameStatus(output)
+
+	return change, nil
+}
+
+// parseNameStatus parses the output of 'git diff --name-status'
+func parseNameStatus(output []byte) []FileChange {
+	var files []FileChange
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		change := FileChange{Status: fields[0][:1], Path: fields[len(fields)-1]}
+		if len(fields) == 3 {
+			change.OldPath = fields[1]
+		}
+		files = append(files, change)
+	}
+	return files
+}
+
+// patchPath turns the "a/<old> b/<new>" part of a diff header into the file
+// path, or "<old> -> <new>" for renames
+func patchPath(header string) string {
+	if i := strings.Index(header, " b/"); strings.HasPrefix(header, "a/") && i > 0 {
+		oldPath, newPath := header[2:i], header[i+3:]
+		if oldPath == newPath {
+			return newPath
+		}
+		return oldPath + " -> " + newPath
+	}
+	return header
+}
+
+// splitPatch splits a git patch into per-file sections keyed by the path
+// taken from the "diff --git" header line
+func splitPatch(patch []byte) map[string]string {
+	sections := make(map[string]string)
+	var header string
+	var body strings.Builder
+
+	flush := func() {
+		if header != "" {
+			sections[header] = body.String()
+		}
+		body.Reset()
+	}
+	for _, line := range strings.SplitAfter(string(patch), "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			flush()
+			header = patchPath(strings.TrimSuffix(strings.TrimPrefix(line, "diff --git "), "\n"))
+			continue
+		}
+		// Blob hashes differ whenever the file content before the module ran
+		// differs, which is already reported by the earlier module
+		if strings.HasPrefix(line, "index ") {
+			continue
+		}
+		body.WriteString(line)
+	}
+	flush()
+	return sections
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a cloak subcommand
+type command struct {
+	name    string
+	usage   string // argument synopsis shown after the command name
+	summary string
+	run     func(args []string) error
+}
+
+// commandTable returns every subcommand in the order they are listed in help
+func commandTable() []command {
+	return []command{
+		{"build", "[flags]", "Clone Sliver, run modules and compile", buildCommand},
+		{"modules", "list | describe <module>", "List registered modules and their parameters", modulesCommand},
+		{"runs", "list | show <run> | prune [-keep N] [-older-than 7d]", "Manage run directories", runsCommand},
+		{"diff", "[-json] <runA> <runB>", "Compare two runs", diffRunsCommand},
+		{"verify", "repro <run>", "Verify a finished run", verifyCommand},
+		{"export-iocs", "[-archive file] <run>", "Export hashes, renames, strings and YARA rules of a run for defenders", exportIOCsCommand},
+		{"audit", "verify [-log file]", "Check the audit log of all runs for tampering or gaps", auditCommand},
+		{"identify", "[-key file] <binary>...", "Show and verify the engagement a binary was built for", identifyCommand},
+		{"clean", "[-keep N]", "Remove source trees from finished runs, keeping metadata and artifacts", cleanCommand},
+		{"toolchain", "list | detect <dir> | install ...", "Manage Go and protoc toolchains", toolchainCommand},
+	}
+}
+
+// usage prints the top-level help
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: cloak <command> [flags] [args]\n\ncommands:\n")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commandTable() {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.usage, cmd.summary)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nRun 'cloak <command> -help' for the flags of a command.\n")
+	fmt.Fprintf(os.Stderr, "Flags take precedence over CLOAK_* environment variables, which take precedence over defaults.\n")
+}
+
+// runCLI dispatches args (without the program name) to a subcommand
+func runCLI(args []string) error {
+	// Bare flags, as in 'cloak -modules all', mean build
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && !isHelpFlag(args[0]) {
+		args = append([]string{"build"}, args...)
+	}
+	if len(args) == 0 || isHelpFlag(args[0]) || args[0] == "help" {
+		usage()
+		return nil
+	}
+
+	// Names used before the subcommands were grouped
+	switch args[0] {
+	case "diff-runs":
+		args[0] = "diff"
+	case "verify-repro":
+		args = append([]string{"verify", "repro"}, args[1:]...)
+	}
+
+	for _, cmd := range commandTable() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// newFlagSet creates the flag set of a subcommand with a usage message built
+// from its synopsis
+func newFlagSet(name, synopsis, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: cloak %s %s\n\n%s\n", name, synopsis, summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nflags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseFlags parses args and returns the positional arguments. Unlike
+// FlagSet.Parse, flags may follow positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// outputFlag registers the -output flag shared by the run commands
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", envOr("CLOAK_OUTPUT", DefaultOutputDir), "Output directory holding the runs (env CLOAK_OUTPUT)")
+}
+
+// modulesDirFlag registers the -modules-dir flag
+func modulesDirFlag(fs *flag.FlagSet) *string {
+	return fs.String("modules-dir", envOr("CLOAK_MODULES_DIR", DefaultModulesDir), "Directory of external module executables (env CLOAK_MODULES_DIR)")
+}
+
+// newModuleBuilder creates a builder with the built-in modules and the
+// external modules found in modulesDir
+func newModuleBuilder(config *Config, verbose bool, modulesDir string) (*Builder, error) {
+	builder := NewBuilder(config, verbose)
+	registerModules(builder)
+	if err := registerExternalModules(builder, modulesDir); err != nil {
+		return nil, err
+	}
+	return builder, nil
+}
+
+// listFlag is a flag that may be given several times
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseSeed parses a -seed value, or returns a random seed if s is empty
+func parseSeed(s string) (int64, error) {
+	if s == "" {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, fmt.Errorf("failed to generate seed: %w", err)
+		}
+		return int64(binary.BigEndian.Uint64(b[:]) >> 1), nil
+	}
+	seed, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid -seed %q: %w", s, err)
+	}
+	return seed, nil
+}
+
+// envBool returns the boolean value of an environment variable, or def when
+// unset or invalid
+func envBool(key string, def bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return def
+}
+
+// buildCommand implements 'cloak build'. Values are taken from, in order of
+// precedence, command line flags, the profile, environment variables and
+// defaults.
+func buildCommand(args []string) error {
+	fs := newFlagSet("build", "[flags]", "Clone Sliver, run the selected modules and compile the client and server.")
+	profilePath := fs.String("profile", os.Getenv("CLOAK_PROFILE"), "Build profile (YAML) describing the build (env CLOAK_PROFILE)")
+	targetVersion := fs.String("target", envOr("TARGET_VERSION", "1.5"), "Target version: 1.5, 1.6 or any git ref (env TARGET_VERSION)")
+	source := fs.String("source", envOr("CLOAK_SOURCE", RepoURL), "Repository to clone (env CLOAK_SOURCE)")
+	modules := fs.String("modules", os.Getenv("CLOAK_MODULES"), "Comma-separated list of modules to run, or 'all' (env CLOAK_MODULES)")
+	makeTargets := fs.String("make", envOr("CLOAK_MAKE", strings.Join(DefaultMakeTargets, ",")), "Comma-separated make targets to build, in order (env CLOAK_MAKE)")
+	outputDir := outputFlag(fs)
+	verbose := fs.Bool("verbose", envBool("CLOAK_VERBOSE", false), "Show build output (env CLOAK_VERBOSE)")
+	reproducible := fs.Bool("reproducible", envBool("CLOAK_REPRODUCIBLE", false), "Build with pinned timestamps, paths and build IDs (env CLOAK_REPRODUCIBLE)")
+	toolchains := fs.String("toolchains", envOr("CLOAK_TOOLCHAINS", DefaultToolchainDir), "Toolchain cache directory (env CLOAK_TOOLCHAINS)")
+	strictToolchain := fs.Bool("strict-toolchain", envBool("CLOAK_STRICT_TOOLCHAIN", false), "Require the exact protoc tools the source was generated with (env CLOAK_STRICT_TOOLCHAIN)")
+	modulesDir := modulesDirFlag(fs)
+	incompatible := fs.String("incompatible", envOr("CLOAK_INCOMPATIBLE", IncompatibleSkip), "What to do with modules that do not support the target: skip or fail (env CLOAK_INCOMPATIBLE)")
+	seed := fs.String("seed", os.Getenv("CLOAK_SEED"), "Seed for randomly generated names, random if empty (env CLOAK_SEED)")
+	residuals := fs.String("residuals", envOr("CLOAK_RESIDUALS", ResidualsWarn), "What to do with module search terms left after the build: warn, fail or off (env CLOAK_RESIDUALS)")
+	smoke := fs.String("smoke", envOr("CLOAK_SMOKE", SmokeOff), "Run the built server and client to check they start: off, warn or fail (env CLOAK_SMOKE)")
+	smokeTimeout := fs.Duration("smoke-timeout", DefaultSmokeTimeout, "Time limit for each smoke check")
+	engagement := fs.String("engagement", os.Getenv("CLOAK_ENGAGEMENT"), "Engagement ID to embed in the binaries with the run's provenance (env CLOAK_ENGAGEMENT)")
+	operator := fs.String("operator", os.Getenv("CLOAK_OPERATOR"), "Operator name recorded with -engagement (env CLOAK_OPERATOR)")
+	auditLog := auditLogFlag(fs)
+	provenanceKey := fs.String("provenance-key", os.Getenv("CLOAK_PROVENANCE_KEY"), "File holding the key to sign the provenance record with (env CLOAK_PROVENANCE_KEY)")
+	var settings listFlag
+	fs.Var(&settings, "set", "Set a module parameter, module.key=value (repeatable, overrides the profile)")
+	var ldVars, buildEnv listFlag
+	fs.Var(&ldVars, "X", "Set a string variable in the binaries, importpath.name=value (repeatable, overrides the profile)")
+	tags := fs.String("tags", os.Getenv("CLOAK_TAGS"), "Comma-separated Go build tags added to the Makefile's TAGS (env CLOAK_TAGS)")
+	goflags := fs.String("goflags", os.Getenv("CLOAK_GOFLAGS"), "Flags added to GOFLAGS for the make step (env CLOAK_GOFLAGS)")
+	fs.Var(&buildEnv, "build-env", "Set an environment variable for the make step, KEY=VALUE (repeatable, overrides the profile)")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(positional, " "))
+	}
+
+	// Profile values apply to every flag not given on the command line
+	profile := &Profile{}
+	if *profilePath != "" {
+		if profile, err = LoadProfile(*profilePath); err != nil {
+			return err
+		}
+		explicit := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		for name, value := range profile.flagValues() {
+			if explicit[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid %s in profile: %w", name, err)
+			}
+		}
+	}
+
+	if *incompatible != IncompatibleSkip && *incompatible != IncompatibleFail {
+		return fmt.Errorf("invalid -incompatible policy %q, use skip or fail", *incompatible)
+	}
+	if *residuals != ResidualsWarn && *residuals != ResidualsFail && *residuals != ResidualsOff {
+		return fmt.Errorf("invalid -residuals policy %q, use warn, fail or off", *residuals)
+	}
+	if *smoke != SmokeOff && *smoke != SmokeWarn && *smoke != SmokeFail {
+		return fmt.Errorf("invalid -smoke policy %q, use off, warn or fail", *smoke)
+	}
+	runSeed, err := parseSeed(*seed)
+	if err != nil {
+		return err
+	}
+	if *engagement == "" && (*operator != "" || *provenanceKey != "") {
+		return errors.New("-operator and -provenance-key require -engagement")
+	}
+	key, err := readProvenanceKey(*provenanceKey)
+	if err != nil {
+		return err
+	}
+
+	// Parameters given on the command line override the profile
+	for _, setting := range settings {
+		module, key, value, err := parseSetting(setting)
+		if err != nil {
+			return err
+		}
+		profile.setParam(module, key, value)
+	}
+
+	// -X and -build-env add to the profile's make section
+	buildVars := profile.Make.Vars
+	for _, pair := range ldVars {
+		if err := setPair(&buildVars.X, pair, "-X"); err != nil {
+			return err
+		}
+	}
+	for _, pair := range buildEnv {
+		if err := setPair(&buildVars.Env, pair, "-build-env"); err != nil {
+			return err
+		}
+	}
+	buildVars.Tags = nil
+	if *tags != "" {
+		buildVars.Tags = strings.Split(*tags, ",")
+	}
+	buildVars.GoFlags = *goflags
+	if err := buildVars.Validate(); err != nil {
+		return err
+	}
+
+	// process user input list
+	var moduleList []string
+	if *modules != "" {
+		moduleList = strings.Split(*modules, ",")
+	}
+
+	// Create the run environment
+	config, err := NewConfig(*targetVersion, *outputDir)
+	if err != nil {
+		return fmt.Errorf("failed to create config: %w", err)
+	}
+	config.RepoURL = *source
+	config.MakeTargets = strings.Split(*makeTargets, ",")
+	config.BuildVars = buildVars
+	config.ModuleParams = profile.Params
+	config.Target.Toolchain = mergeToolchain(config.Target.Toolchain, profile.toolchain())
+	config.Reproducible = *reproducible
+	config.ToolchainDir = *toolchains
+	config.StrictToolchain = *strictToolchain
+	config.Incompatible = *incompatible
+	config.Residuals = *residuals
+	config.Smoke = *smoke
+	config.SmokeTimeout = *smokeTimeout
+	config.Seed = runSeed
+	config.Engagement = *engagement
+	config.Operator = *operator
+	config.ProvenanceKey = key
+	config.AuditLog = auditLogPath(*auditLog, *outputDir)
+
+	// Record the effective flags the run was started with
+	config.Flags = make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		config.Flags[f.Name] = f.Value.String()
+	})
+	config.Flags["seed"] = strconv.FormatInt(config.Seed, 10)
+
+	log.Println("Target version:", config.Target.Tag)
+	log.Println("Run directory:", config.RunDir)
+
+	// create our builder
+	builder, err := newModuleBuilder(config, *verbose, *modulesDir)
+	if err != nil {
+		return err
+	}
+
+	// clone, process, build
+	return builder.Run(moduleList)
+}
+
+// modulesCommand implements 'cloak modules list|describe'
+func modulesCommand(args []string) error {
+	const synopsis = "list | describe <module>"
+	fs := newFlagSet("modules", synopsis, "List the registered modules, or show the description and parameters of one.")
+	modulesDir := modulesDirFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	builder, err := newModuleBuilder(nil, false, *modulesDir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(positional) == 1 && positional[0] == "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tPARAMETERS\tDESCRIPTION")
+		for _, m := range builder.Modules() {
+			var names []string
+			for _, p := range moduleParams(m) {
+				names = append(names, p.Name)
+			}
+			params := strings.Join(names, ",")
+			if params == "" {
+				params = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", m.Name(), params, moduleDescription(m))
+		}
+		return w.Flush()
+
+	case len(positional) == 2 && positional[0] == "describe":
+		m, ok := builder.modules[positional[1]]
+		if !ok {
+			return fmt.Errorf("module %s not found", positional[1])
+		}
+		fmt.Printf("%s: %s\n", m.Name(), moduleDescription(m))
+		if c, ok := m.(Compatible); ok {
+			compat := c.Compatibility()
+			fmt.Println("\nrequires:")
+			if compat.Versions != "" {
+				fmt.Printf("  sliver %s\n", compat.Versions)
+			}
+			for _, file := range compat.Files {
+				fmt.Printf("  file %s\n", file)
+			}
+			for _, symbol := range compat.Symbols {
+				fmt.Printf("  %q in %s\n", symbol.Text, symbol.File)
+			}
+		}
+
+		params := moduleParams(m)
+		if len(params) == 0 {
+			fmt.Println("\nparameters: none")
+			return nil
+		}
+		fmt.Println("\nparameters:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tTYPE\tDEFAULT\tDESCRIPTION")
+		for _, p := range params {
+			def := p.Default
+			if p.Required {
+				def = "(required)"
+			}
+			desc := p.Description
+			if len(p.Choices) > 0 {
+				desc += " (one of " + strings.Join(p.Choices, ", ") + ")"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", p.Name, p.paramType(), def, desc)
+		}
+		fmt.Fprintf(w, "\nset with: cloak build -set %s.<name>=<value>\n", m.Name())
+		return w.Flush()
+
+	default:
+		fs.Usage()
+		return errors.New("usage: cloak modules " + synopsis)
+	}
+}
+
+// verifyCommand implements 'cloak verify <check> <run>'
+func verifyCommand(args []string) error {
+	if len(args) == 0 || isHelpFlag(args[0]) {
+		fmt.Fprintf(os.Stderr, "usage: cloak verify <check> [flags] <run>\n\nchecks:\n  repro      rebuild the run from its recorded inputs and compare artifact hashes\n  residuals  scan the run's source tree and artifacts for module search terms\n  smoke      run the run's server and client binaries and check that they start\n")
+		if len(args) == 0 {
+			return errors.New("missing verify check")
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "repro":
+		return verifyReproCommand(args[1:])
+	case "residuals":
+		return verifyResidualsCommand(args[1:])
+	case "smoke":
+		return verifySmokeCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown verify check %q", args[0])
+	}
+}
+
+// cleanCommand implements 'cloak clean'
+func cleanCommand(args []string) error {
+	fs := newFlagSet("clean", "[flags]", "Remove the cloned source trees of finished runs. run.json, module patches and artifacts are kept.")
+	outputDir := outputFlag(fs)
+	keep := fs.Int("keep", 0, "Leave the N most recent runs untouched")
+	dryRun := fs.Bool("dry-run", false, "Print what would be removed")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	runs, err := listRuns(*outputDir)
+	if err != nil {
+		return err
+	}
+	for i, run := range runs {
+		if i >= len(runs)-*keep || run.Status == RunStatusRunning {
+			continue
+		}
+		for _, name := range []string{"sliver", ".cloak-index", "cloak.mk"} {
+			path := filepath.Join(run.Dir(), name)
+			if !fileExists(path) {
+				continue
+			}
+			if *dryRun {
+				fmt.Println("would remove", path)
+				continue
+			}
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to clean run %s: %w", run.ID, err)
+			}
+		}
+		if !*dryRun {
+			log.Println("Cleaned run:", run.ID)
+		}
+	}
+	return nil
+}
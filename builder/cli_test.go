@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"testing"
+)
+
+// TestCommandHelp checks that -h and --help succeed on every level, as main
+// exits 0 on flag.ErrHelp
+func TestCommandHelp(t *testing.T) {
+	for _, args := range [][]string{
+		{"--help"},
+		{"runs", "--help"},
+		{"runs", "-h"},
+		{"runs", "list", "--help"},
+		{"toolchain", "--help"},
+		{"toolchain", "list", "-h"},
+		{"audit", "--help"},
+		{"audit", "verify", "--help"},
+		{"verify", "--help"},
+	} {
+		if err := runCLI(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+}
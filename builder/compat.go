@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Policies for modules that are not compatible with the target
+const (
+	IncompatibleSkip = "skip" // warn and continue without the module
+	IncompatibleFail = "fail" // fail the run before any module runs
+)
+
+// Compatibility declares what a module needs from the source tree. Every
+// non-empty field must be satisfied.
+type Compatibility struct {
+	// Versions is a space or comma separated list of constraints on the
+	// Sliver version, e.g. ">=1.5.0 <1.6.0"
+	Versions string
+	// Files must exist, relative to the source tree
+	Files []string
+	// Symbols must appear in the given files
+	Symbols []Symbol
+}
+
+// Symbol is a string that must appear in a file of the source tree
+type Symbol struct {
+	File string `json:"file"`
+	Text string `json:"text"`
+}
+
+// Compatible is implemented by modules that only work with some targets.
+// It is called after Configure, so declarations may depend on parameters.
+type Compatible interface {
+	Compatibility() Compatibility
+}
+
+// SkippedModule records a module left out of a run
+type SkippedModule struct {
+	Module string `json:"module"`
+	Reason string `json:"reason"`
+}
+
+// sliverVersion returns the nearest release tag of the checked out commit,
+// or an empty string if the tree has no tags
+func (b *Builder) sliverVersion() string {
+	cmd := exec.Command("git", "describe", "--tags", "--abbrev=0", "--match", "v[0-9]*", "HEAD")
+	cmd.Dir = filepath.Join(b.config.RunDir, "sliver")
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// checkCompatibility returns why the source tree does not satisfy c, or an
+// empty string if it does
+func checkCompatibility(c Compatibility, srcDir, version string) (string, error) {
+	if constraints := strings.Fields(strings.ReplaceAll(c.Versions, ",", " ")); len(constraints) > 0 {
+		if version == "" {
+			return "target version is unknown, module requires " + c.Versions, nil
+		}
+		for _, constraint := range constraints {
+			ok, err := versionSatisfies(version, constraint)
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				return fmt.Sprintf("target version %s does not satisfy %s", version, c.Versions), nil
+			}
+		}
+	}
+
+	for _, file := range c.Files {
+		if !fileExists(filepath.Join(srcDir, file)) {
+			return "missing file " + file, nil
+		}
+	}
+
+	for _, symbol := range c.Symbols {
+		data, err := os.ReadFile(filepath.Join(srcDir, symbol.File))
+		if os.IsNotExist(err) {
+			return "missing file " + symbol.File, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Contains(data, []byte(symbol.Text)) {
+			return fmt.Sprintf("%s does not contain %q", symbol.File, symbol.Text), nil
+		}
+	}
+	return "", nil
+}
+
+// versionSatisfies checks a version against a single constraint such as
+// ">=1.5.0", "<1.6" or "1.5.42" (exact)
+func versionSatisfies(version, constraint string) (bool, error) {
+	op := strings.TrimRight(constraint, "0123456789.v")
+	want := constraint[len(op):]
+	if want == "" || len(parseVersion(want)) == 0 {
+		return false, fmt.Errorf("invalid version constraint %q", constraint)
+	}
+
+	cmp := compareVersions(version, want)
+	switch op {
+	case "", "=", "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	}
+	return false, fmt.Errorf("invalid version constraint %q", constraint)
+}
+
+// filterCompatible checks the selected modules against the cloned tree and
+// returns the ones to run. Depending on the configured policy incompatible
+// modules are skipped with a warning or fail the run.
+func (b *Builder) filterCompatible(moduleNames []string) ([]string, error) {
+	srcDir := filepath.Join(b.config.RunDir, "sliver")
+	version := b.sliverVersion()
+	b.meta.Version = version
+
+	var compatible []string
+	for _, name := range moduleNames {
+		c, ok := b.modules[name].(Compatible)
+		if !ok {
+			compatible = append(compatible, name)
+			continue
+		}
+
+		reason, err := checkCompatibility(c.Compatibility(), srcDir, version)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", name, err)
+		}
+		if reason == "" {
+			compatible = append(compatible, name)
+			continue
+		}
+
+		if b.config.Incompatible == IncompatibleFail {
+			return nil, fmt.Errorf("module %s is not compatible with %s: %s", name, b.config.Target.Tag, reason)
+		}
+		log.Printf("Warning: skipping module %s: %s", name, reason)
+		b.meta.Skipped = append(b.meta.Skipped, SkippedModule{Module: name, Reason: reason})
+	}
+	return compatible, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ToolchainRequirements are the tool versions a source tree asks for
+type ToolchainRequirements struct {
+	GoDirective        string `json:"go_directive"`                  // "go" line of go.mod
+	ToolchainDirective string `json:"toolchain_directive,omitempty"` // "toolchain" line of go.mod
+	Protoc             string `json:"protoc,omitempty"`              // from generated .pb.go headers
+	ProtocGenGo        string `json:"protoc_gen_go,omitempty"`
+	ProtocGenGoGrpc    string `json:"protoc_gen_go_grpc,omitempty"`
+}
+
+// detectRequirements reads go.mod and the headers of the generated protobuf
+// code in a source tree
+func detectRequirements(srcDir string) (*ToolchainRequirements, error) {
+	req := &ToolchainRequirements{}
+
+	f, err := os.Open(filepath.Join(srcDir, "go.mod"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "go":
+			req.GoDirective = fields[1]
+		case "toolchain":
+			req.ToolchainDirective = strings.TrimPrefix(fields[1], "go")
+		}
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	if req.GoDirective == "" {
+		return nil, fmt.Errorf("go.mod has no go directive")
+	}
+
+	// Generated files start with e.g.
+	//   // 	protoc-gen-go v1.27.1
+	//   // 	protoc        v4.22.2
+	//   // - protoc-gen-go-grpc v1.2.0
+	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); name == ".git" || name == "vendor" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".pb.go") {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for i := 0; i < 10 && scanner.Scan(); i++ {
+			fields := strings.Fields(strings.TrimLeft(scanner.Text(), "/ \t-"))
+			if len(fields) != 2 || !strings.HasPrefix(fields[1], "v") {
+				continue
+			}
+			switch fields[0] {
+			case ToolProtoc:
+				req.Protoc = maxVersion(req.Protoc, protocRelease(fields[1]))
+			case ToolProtocGenGo:
+				req.ProtocGenGo = maxVersion(req.ProtocGenGo, fields[1])
+			case ToolProtocGenGoGrpc:
+				req.ProtocGenGoGrpc = maxVersion(req.ProtocGenGoGrpc, fields[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan generated protobuf code: %w", err)
+	}
+
+	return req, nil
+}
+
+// protocRelease maps the protoc version recorded in generated code (or
+// printed by 'protoc --version') to its release name: v3.19.4 -> 3.19.4,
+// v3.21.12 -> 21.12, v4.22.2 -> 22.2
+func protocRelease(version string) string {
+	v := parseVersion(version)
+	if len(v) >= 3 && ((v[0] >= 4 && v[0] < 21) || (v[0] == 3 && v[1] >= 21)) {
+		return formatVersion(v[1:])
+	}
+	return strings.TrimPrefix(version, "v")
+}
+
+// parseVersion parses "go1.18.10", "v1.2.3" or "1.21" into its numeric parts
+func parseVersion(version string) []int {
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "go"), "v")
+	var parts []int
+	for _, s := range strings.Split(version, ".") {
+		// Drop pre-release suffixes such as "rc1"
+		if i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+			s = s[:i]
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// formatVersion joins numeric version parts with dots
+func formatVersion(parts []int) string {
+	s := make([]string, len(parts))
+	for i, p := range parts {
+		s[i] = strconv.Itoa(p)
+	}
+	return strings.Join(s, ".")
+}
+
+// compareVersions compares two version strings numerically, treating
+// missing parts as zero
+func compareVersions(a, b string) int {
+	va, vb := parseVersion(a), parseVersion(b)
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// maxVersion returns the higher of two versions, ignoring empty ones
+func maxVersion(a, b string) string {
+	if a == "" || compareVersions(b, a) > 0 {
+		return b
+	}
+	return a
+}
+
+// acceptsGo reports whether a Go version can build a module with the given
+// requirements. Before Go 1.21 the go directive did not enforce a minimum,
+// and old trees (e.g. Sliver 1.5 on Go 1.18) only build with that release,
+// so the minor version must match. Newer trees accept any release at or
+// above both directives.
+func (r *ToolchainRequirements) acceptsGo(version string) bool {
+	if compareVersions(r.GoDirective, "1.21") < 0 {
+		want, have := parseVersion(r.GoDirective), parseVersion(version)
+		return len(want) >= 2 && len(have) >= 2 && want[0] == have[0] && want[1] == have[1]
+	}
+	return compareVersions(version, r.GoDirective) >= 0 &&
+		compareVersions(version, r.ToolchainDirective) >= 0
+}
+
+// resolveToolchain picks the tool versions to build a tree with from the
+// toolchain cache and the PATH. Go must satisfy the tree's go.mod; protoc and
+// its plugins use the exact version from the generated code when it is
+// available and otherwise fall back to the PATH, unless strict is set.
+func resolveToolchain(req *ToolchainRequirements, cache *ToolCache, env []string, strict bool) (Toolchain, error) {
+	var tc Toolchain
+
+	installed, err := cache.List()
+	if err != nil {
+		return tc, err
+	}
+
+	// Prefer the exact toolchain directive, then the Go on the PATH, then the
+	// newest cached release that satisfies go.mod
+	var candidates []string
+	seen := make(map[string]bool)
+	addCandidate := func(version string) {
+		if version != "" && !seen[version] {
+			seen[version] = true
+			candidates = append(candidates, version)
+		}
+	}
+	addCandidate(req.ToolchainDirective)
+	if system, err := systemToolVersion(ToolGo, env); err == nil {
+		addCandidate(normalizeVersion(ToolGo, system))
+	}
+	cached := append([]string(nil), installed[ToolGo]...)
+	sort.Slice(cached, func(i, j int) bool { return compareVersions(cached[i], cached[j]) > 0 })
+	for _, version := range cached {
+		addCandidate(version)
+	}
+
+	for _, version := range candidates {
+		if req.acceptsGo(version) && (cache.Installed(ToolGo, version) || inPath(ToolGo, version, env)) {
+			tc.Go = version
+			break
+		}
+	}
+	if tc.Go == "" {
+		want := "go " + req.GoDirective + ".x"
+		if compareVersions(req.GoDirective, "1.21") >= 0 {
+			want = "go >= " + maxVersion(req.GoDirective, req.ToolchainDirective)
+		}
+		return tc, fmt.Errorf("source tree requires %s but none is installed (found %s); run: cloak toolchain install go <version>",
+			want, strings.Join(candidates, ", "))
+	}
+
+	for _, tool := range []struct {
+		name    string
+		version string
+		pin     *string
+	}{
+		{ToolProtoc, req.Protoc, &tc.Protoc},
+		{ToolProtocGenGo, req.ProtocGenGo, &tc.ProtocGenGo},
+		{ToolProtocGenGoGrpc, req.ProtocGenGoGrpc, &tc.ProtocGenGoGrpc},
+	} {
+		switch {
+		case tool.version == "":
+			// Not referenced by the tree, use whatever is on the PATH
+		case strict || cache.Installed(tool.name, tool.version) || inPath(tool.name, tool.version, env):
+			*tool.pin = tool.version
+		default:
+			current, err := systemToolVersion(tool.name, env)
+			if err != nil {
+				return tc, fmt.Errorf("source tree was generated with %s %s, which is not installed and no %s is on the PATH; run: cloak toolchain install %s %s",
+					tool.name, tool.version, tool.name, tool.name, tool.version)
+			}
+			log.Printf("Warning: source tree was generated with %s %s, using %s from the PATH", tool.name, tool.version, current)
+		}
+	}
+
+	return tc, nil
+}
+
+// inPath reports whether the given version of a tool is the one on the PATH
+func inPath(tool, version string, env []string) bool {
+	current, err := systemToolVersion(tool, env)
+	return err == nil && normalizeVersion(tool, current) == normalizeVersion(tool, version)
+}
+
+// mergeToolchain returns detected with any versions pinned in override
+// taking precedence
+func mergeToolchain(detected, override Toolchain) Toolchain {
+	if override.Go != "" {
+		detected.Go = override.Go
+	}
+	if override.Protoc != "" {
+		detected.Protoc = override.Protoc
+	}
+	if override.ProtocGenGo != "" {
+		detected.ProtocGenGo = override.ProtocGenGo
+	}
+	if override.ProtocGenGoGrpc != "" {
+		detected.ProtocGenGoGrpc = override.ProtocGenGoGrpc
+	}
+	return detected
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ValueDiff is a scalar value that differs between two runs
+type ValueDiff struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// PatchDiff describes how the changes a module made differ between two runs
+type PatchDiff struct {
+	Module  string   `json:"module"`
+	OnlyInA []string `json:"only_in_a,omitempty"` // files changed only in run A
+	OnlyInB []string `json:"only_in_b,omitempty"` // files changed only in run B
+	Differs []string `json:"differs,omitempty"`   // files changed differently
+}
+
+// ArtifactDiff describes an artifact whose hash or size differs between runs
+type ArtifactDiff struct {
+	Name    string `json:"name"`
+	SHA256A string `json:"sha256_a,omitempty"`
+	SHA256B string `json:"sha256_b,omitempty"`
+	SizeA   int64  `json:"size_a,omitempty"`
+	SizeB   int64  `json:"size_b,omitempty"`
+}
+
+// RunDiff is the result of comparing two runs
+type RunDiff struct {
+	A         string         `json:"a"`
+	B         string         `json:"b"`
+	Upstream  []ValueDiff    `json:"upstream,omitempty"`
+	Modules   *ValueDiff     `json:"modules,omitempty"`
+	Flags     []ValueDiff    `json:"flags,omitempty"`
+	Params    []ValueDiff    `json:"params,omitempty"` // keyed module.parameter
+	Patches   []PatchDiff    `json:"patches,omitempty"`
+	Artifacts []ArtifactDiff `json:"artifacts,omitempty"`
+}
+
+// Empty reports whether the runs are equivalent
+func (d *RunDiff) Empty() bool {
+	return len(d.Upstream) == 0 && d.Modules == nil && len(d.Flags) == 0 &&
+		len(d.Params) == 0 && len(d.Patches) == 0 && len(d.Artifacts) == 0
+}
+
+// compareRuns compares the inputs, module changes and outputs of two runs
+func compareRuns(a, b *RunMeta) (*RunDiff, error) {
+	diff := &RunDiff{A: a.ID, B: b.ID}
+
+	for _, v := range []ValueDiff{
+		{"repo_url", a.RepoURL, b.RepoURL},
+		{"git_ref", a.GitRef, b.GitRef},
+		{"commit", a.Commit, b.Commit},
+	} {
+		if v.A != v.B {
+			diff.Upstream = append(diff.Upstream, v)
+		}
+	}
+
+	modulesA, modulesB := strings.Join(a.Modules, ","), strings.Join(b.Modules, ",")
+	if modulesA != modulesB {
+		diff.Modules = &ValueDiff{Field: "modules", A: modulesA, B: modulesB}
+	}
+
+	for _, key := range unionKeys(a.Flags, b.Flags) {
+		if a.Flags[key] != b.Flags[key] {
+			diff.Flags = append(diff.Flags, ValueDiff{Field: key, A: a.Flags[key], B: b.Flags[key]})
+		}
+	}
+
+	for _, module := range unionKeys(a.Params, b.Params) {
+		paramsA, paramsB := a.Params[module], b.Params[module]
+		for _, key := range unionKeys(paramsA, paramsB) {
+			if paramsA[key] != paramsB[key] {
+				diff.Params = append(diff.Params, ValueDiff{Field: module + "." + key, A: paramsA[key], B: paramsB[key]})
+			}
+		}
+	}
+
+	patchesA, err := loadPatches(a)
+	if err != nil {
+		return nil, err
+	}
+	patchesB, err := loadPatches(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, module := range unionKeys(patchesA, patchesB) {
+		sectionsA, sectionsB := patchesA[module], patchesB[module]
+		pd := PatchDiff{Module: module}
+		for _, file := range unionKeys(sectionsA, sectionsB) {
+			bodyA, inA := sectionsA[file]
+			bodyB, inB := sectionsB[file]
+			switch {
+			case !inB:
+				pd.OnlyInA = append(pd.OnlyInA, file)
+			case !inA:
+				pd.OnlyInB = append(pd.OnlyInB, file)
+			case bodyA != bodyB:
+				pd.Differs = append(pd.Differs, file)
+			}
+		}
+		if len(pd.OnlyInA)+len(pd.OnlyInB)+len(pd.Differs) > 0 {
+			diff.Patches = append(diff.Patches, pd)
+		}
+	}
+
+	artifactsA, artifactsB := make(map[string]Artifact), make(map[string]Artifact)
+	for _, artifact := range a.Artifacts {
+		artifactsA[artifact.Name] = artifact
+	}
+	for _, artifact := range b.Artifacts {
+		artifactsB[artifact.Name] = artifact
+	}
+	for _, name := range unionKeys(artifactsA, artifactsB) {
+		artA, artB := artifactsA[name], artifactsB[name]
+		if artA.SHA256 != artB.SHA256 || artA.Size != artB.Size {
+			diff.Artifacts = append(diff.Artifacts, ArtifactDiff{
+				Name:    name,
+				SHA256A: artA.SHA256,
+				SHA256B: artB.SHA256,
+				SizeA:   artA.Size,
+				SizeB:   artB.Size,
+			})
+		}
+	}
+
+	return diff, nil
+}
+
+// loadPatches reads every module patch of a run, split per file
+func loadPatches(run *RunMeta) (map[string]map[string]string, error) {
+	patches := make(map[string]map[string]string)
+	for _, change := range run.Changes {
+		data, err := os.ReadFile(filepath.Join(run.Dir(), change.Patch))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patch for module %s of run %s: %w", change.Module, run.ID, err)
+		}
+		patches[change.Module] = splitPatch(data)
+	}
+	return patches, nil
+}
+
+// unionKeys returns the sorted union of the keys of two maps
+func unionKeys[V any](a, b map[string]V) []string {
+	seen := make(map[string]bool)
+	for k := range a {
+		seen[k] = true
+	}
+	for k := range b {
+		seen[k] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeText prints the diff in a human readable form
+func (d *RunDiff) writeText(w io.Writer) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", d.A, d.B)
+	if d.Empty() {
+		fmt.Fprintln(w, "runs are identical")
+		return
+	}
+
+	for _, v := range d.Upstream {
+		fmt.Fprintf(w, "upstream %s: %s -> %s\n", v.Field, v.A, v.B)
+	}
+	if d.Modules != nil {
+		fmt.Fprintf(w, "modules: %s -> %s\n", d.Modules.A, d.Modules.B)
+	}
+	for _, v := range d.Flags {
+		fmt.Fprintf(w, "flag %s: %q -> %q\n", v.Field, v.A, v.B)
+	}
+	for _, v := range d.Params {
+		fmt.Fprintf(w, "param %s: %q -> %q\n", v.Field, v.A, v.B)
+	}
+	for _, pd := range d.Patches {
+		fmt.Fprintf(w, "module %s:\n", pd.Module)
+		for _, file := range pd.OnlyInA {
+			fmt.Fprintf(w, "  - %s\n", file)
+		}
+		for _, file := range pd.OnlyInB {
+			fmt.Fprintf(w, "  + %s\n", file)
+		}
+		for _, file := range pd.Differs {
+			fmt.Fprintf(w, "  ~ %s\n", file)
+		}
+	}
+	for _, ad := range d.Artifacts {
+		switch {
+		case ad.SHA256A == "":
+			fmt.Fprintf(w, "artifact %s: only in %s (%d bytes)\n", ad.Name, d.B, ad.SizeB)
+		case ad.SHA256B == "":
+			fmt.Fprintf(w, "artifact %s: only in %s (%d bytes)\n", ad.Name, d.A, ad.SizeA)
+		default:
+			fmt.Fprintf(w, "artifact %s: %s (%d bytes) -> %s (%d bytes)\n",
+				ad.Name, ad.SHA256A[:12], ad.SizeA, ad.SHA256B[:12], ad.SizeB)
+		}
+	}
+}
+
+// diffRunsCommand implements 'cloak diff <runA> <runB>'
+func diffRunsCommand(args []string) error {
+	fs := newFlagSet("diff", "[-json] <runA> <runB>", "Compare upstream commits, modules, flags, module patches and artifacts of two runs.")
+	outputDir := outputFlag(fs)
+	asJSON := fs.Bool("json", false, "Print the differences as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: cloak diff [-json] <runA> <runB>")
+	}
+
+	a, err := findRun(*outputDir, positional[0])
+	if err != nil {
+		return err
+	}
+	b, err := findRun(*outputDir, positional[1])
+	if err != nil {
+		return err
+	}
+
+	diff, err := compareRuns(a, b)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+	diff.writeText(os.Stdout)
+	return nil
+}
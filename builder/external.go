@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultModulesDir is searched for external module executables
+const DefaultModulesDir = "/opt/cloak/modules"
+
+// ExternalProtocolVersion is sent with every request to external modules
+const ExternalProtocolVersion = 1
+
+// ExternalTimeout bounds a single invocation of an external module
+const ExternalTimeout = 30 * time.Minute
+
+// ExternalRequest is written as JSON to an external module's stdin. Action
+// is "describe" (at startup) or "run".
+type ExternalRequest struct {
+	Protocol  int             `json:"protocol"`
+	Action    string          `json:"action"`
+	Module    string          `json:"module"`
+	RunDir    string          `json:"run_dir,omitempty"`
+	SourceDir string          `json:"source_dir,omitempty"`
+	Target    *ExternalTarget `json:"target,omitempty"`
+	Params    ParamValues     `json:"params,omitempty"`
+	Verbose   bool            `json:"verbose"`
+}
+
+// ExternalTarget is the Sliver version being built
+type ExternalTarget struct {
+	Tag    string `json:"tag"`
+	GitRef string `json:"git_ref"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// ExternalDescription is the reply to a "describe" request
+type ExternalDescription struct {
+	Description   string                 `json:"description"`
+	Params        []ExternalParam        `json:"params,omitempty"`
+	Compatibility *ExternalCompatibility `json:"compatibility,omitempty"`
+}
+
+// ExternalCompatibility declares the targets an external module supports,
+// see Compatibility
+type ExternalCompatibility struct {
+	Versions string   `json:"versions,omitempty"`
+	Files    []string `json:"files,omitempty"`
+	Symbols  []Symbol `json:"symbols,omitempty"`
+}
+
+// ExternalParam declares a parameter of an external module, see Param
+type ExternalParam struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Choices     []string `json:"choices,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// ExternalResult is the reply to a "run" request
+type ExternalResult struct {
+	OK      bool     `json:"ok"`
+	Summary string   `json:"summary,omitempty"`
+	Changed []string `json:"changed,omitempty"` // paths relative to the source tree
+	Error   string   `json:"error,omitempty"`
+}
+
+// ExternalModule runs an executable as a module. The request is sent on
+// stdin and the result read from stdout; stderr is treated as log output.
+type ExternalModule struct {
+	name   string
+	path   string
+	desc   ExternalDescription
+	params ParamValues
+}
+
+// NewExternalModule describes the executable at path and returns it as a
+// module named after the file, without its extension
+func NewExternalModule(path string) (*ExternalModule, error) {
+	base := filepath.Base(path)
+	m := &ExternalModule{
+		name: strings.TrimSuffix(base, filepath.Ext(base)),
+		path: path,
+	}
+	if m.name == "" || strings.ContainsAny(m.name, ".,= ") {
+		return nil, fmt.Errorf("invalid module name %q", m.name)
+	}
+
+	if err := m.call(ExternalRequest{Action: "describe"}, false, &m.desc); err != nil {
+		return nil, fmt.Errorf("failed to describe module %s: %w", m.name, err)
+	}
+	for _, p := range m.Params() {
+		switch p.Type {
+		case "", ParamString, ParamInt, ParamBool, ParamList:
+		default:
+			return nil, fmt.Errorf("parameter %s has unknown type %s", p.Name, p.Type)
+		}
+		if p.Default == "" {
+			continue
+		}
+		if err := p.check(p.Default); err != nil {
+			return nil, fmt.Errorf("default of parameter %s: %w", p.Name, err)
+		}
+	}
+	return m, nil
+}
+
+func (m *ExternalModule) Name() string {
+	return m.name
+}
+
+func (m *ExternalModule) Description() string {
+	return strings.TrimSpace(m.desc.Description + " [" + m.path + "]")
+}
+
+func (m *ExternalModule) Params() []Param {
+	params := make([]Param, len(m.desc.Params))
+	for i, p := range m.desc.Params {
+		params[i] = Param{
+			Name:        p.Name,
+			Type:        ParamType(p.Type),
+			Default:     p.Default,
+			Required:    p.Required,
+			Choices:     p.Choices,
+			Description: p.Description,
+		}
+	}
+	return params
+}
+
+func (m *ExternalModule) Compatibility() Compatibility {
+	if m.desc.Compatibility == nil {
+		return Compatibility{}
+	}
+	return Compatibility{
+		Versions: m.desc.Compatibility.Versions,
+		Files:    m.desc.Compatibility.Files,
+		Symbols:  m.desc.Compatibility.Symbols,
+	}
+}
+
+func (m *ExternalModule) Configure(values ParamValues) error {
+	m.params = values
+	return nil
+}
+
+func (m *ExternalModule) Run(config *Config, verbose bool) error {
+	req := ExternalRequest{
+		Action:    "run",
+		RunDir:    config.RunDir,
+		SourceDir: filepath.Join(config.RunDir, "sliver"),
+		Target: &ExternalTarget{
+			Tag:    config.Target.Tag,
+			GitRef: config.Target.GitRef,
+			Commit: config.Commit,
+		},
+		Params:  m.params,
+		Verbose: verbose,
+	}
+
+	var result ExternalResult
+	if err := m.call(req, verbose, &result); err != nil {
+		return err
+	}
+	if !result.OK {
+		if result.Error == "" {
+			result.Error = "module reported failure"
+		}
+		return fmt.Errorf("%s", result.Error)
+	}
+
+	if result.Summary != "" {
+		log.Printf("[%s] %s", m.name, result.Summary)
+	}
+	if verbose {
+		for _, path := range result.Changed {
+			log.Printf("[%s] changed %s", m.name, path)
+		}
+	}
+	return nil
+}
+
+// call runs the executable with req on stdin and decodes its stdout into
+// reply. stderr is logged as it is written when verbose, and otherwise
+// returned with the error if the call fails.
+func (m *ExternalModule) call(req ExternalRequest, verbose bool, reply interface{}) error {
+	req.Protocol = ExternalProtocolVersion
+	req.Module = m.name
+	input, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExternalTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, m.path)
+	cmd.Stdin = bytes.NewReader(input)
+	if req.SourceDir != "" {
+		cmd.Dir = req.SourceDir
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if verbose {
+		pr, pw := io.Pipe()
+		cmd.Stderr = pw
+		done := make(chan struct{})
+		go func() {
+			scanner := bufio.NewScanner(pr)
+			for scanner.Scan() {
+				log.Printf("[%s] %s", m.name, scanner.Text())
+			}
+			io.Copy(io.Discard, pr)
+			close(done)
+		}()
+		defer func() {
+			pw.Close()
+			<-done
+		}()
+	} else {
+		cmd.Stderr = &stderr
+	}
+
+	runErr := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s", ExternalTimeout)
+	}
+
+	// A failing module may still explain itself on stdout
+	decodeErr := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), reply)
+	if runErr != nil {
+		if result, ok := reply.(*ExternalResult); ok && decodeErr == nil && result.Error != "" {
+			return fmt.Errorf("%s", result.Error)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", runErr, msg)
+		}
+		return runErr
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("invalid reply on stdout: %w", decodeErr)
+	}
+	return nil
+}
+
+// registerExternalModules registers every executable in dir as a module.
+// A missing directory is not an error. Executables that fail to describe
+// themselves or clash with a registered module are skipped with a warning.
+func registerExternalModules(builder *Builder, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read modules directory: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if strings.HasPrefix(entry.Name(), ".") || err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		m, err := NewExternalModule(path)
+		if err != nil {
+			log.Printf("Warning: skipping external module %s: %v", path, err)
+			continue
+		}
+		if _, exists := builder.modules[m.Name()]; exists {
+			log.Printf("Warning: skipping external module %s: a module named %s is already registered", path, m.Name())
+			continue
+		}
+		builder.RegisterModule(m)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+// recorder is an external module that saves each run request next to itself
+const recorder = `#!/bin/sh
+req=$(cat)
+case "$req" in
+*'"action":"describe"'*) echo '{"description":"Records run requests"}' ;;
+*) printf '%s' "$req" > "$0.json" && echo '{"ok":true}' ;;
+esac
+`
+
+func TestExternalModuleRequest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "recorder.sh")
+	if err := os.WriteFile(path, []byte(recorder), 0755); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewExternalModule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Configure(ParamValues{}); err != nil {
+		t.Fatal(err)
+	}
+
+	runDir := moduletest.Setup(t, fixture)
+	config := &Config{
+		RunDir: runDir,
+		Commit: "0123456789abcdef0123456789abcdef01234567",
+		Target: BuildTarget{Tag: Version1_5, GitRef: Version1_5},
+	}
+	if err := m.Run(config, false); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req ExternalRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Action != "run" || req.Module != "recorder" || req.SourceDir != filepath.Join(runDir, moduletest.SourceDir) {
+		t.Errorf("request = %+v", req)
+	}
+	if req.Target == nil || req.Target.Commit != config.Commit || req.Target.Tag != Version1_5 {
+		t.Errorf("target = %+v, want commit %s", req.Target, config.Commit)
+	}
+}
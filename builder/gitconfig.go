@@ -6,59 +6,115 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type BuildTarget struct {
-	Tag         string
-	GitRef      string
-	UseGoLegacy bool // true for 1.5 which needs Go 1.18
+	Tag       string
+	GitRef    string
+	Commit    string    // optional, pins the checkout to an exact commit
+	Toolchain Toolchain // optional overrides, otherwise detected from the tree
+}
+
+// buildTargets maps the short target versions to their git refs. Any other
+// target is used as a git ref (tag, branch or commit) directly.
+var buildTargets = map[string]BuildTarget{
+	"1.5": {
+		Tag:    Version1_5,
+		GitRef: Version1_5,
+	},
+	"1.6": {
+		Tag:    Version1_6,
+		GitRef: Version1_6,
+	},
 }
 
 type Config struct {
-	RepoURL string
-	RunDir  string // Path to current run directory
-	Target  BuildTarget
+	RepoURL   string
+	OutputDir string // Directory holding all run directories
+	RunDir    string // Path to current run directory
+	Commit    string // Commit checked out in the run's source tree, set after cloning
+	Target    BuildTarget
+	Flags     map[string]string // Command line flags, recorded in run.json
+
+	ModuleParams map[string]ParamValues // Module parameters: module -> key -> value
+	MakeTargets  []string               // make targets to build, in order
+	BuildEnv     []string               // Extra KEY=VALUE environment for the make step
+	LDFlags      []string               // Extra linker flags appended to the Makefile's LDFLAGS
+	BuildVars    BuildVars              // -X, TAGS, GOFLAGS and environment overrides for make
+	Incompatible string                 // IncompatibleSkip or IncompatibleFail
+	Residuals    string                 // ResidualsWarn, ResidualsFail or ResidualsOff
+	Smoke        string                 // SmokeOff, SmokeWarn or SmokeFail
+	SmokeTimeout time.Duration          // Time limit for each smoke check, DefaultSmokeTimeout if 0
+	Seed         int64                  // Seed for randomly generated names, see pkg/names
+	Renames      []Replacement          // Replacements made by the modules run so far, in order
+
+	Engagement    string            // Engagement ID embedded in the binaries, none if empty
+	Operator      string            // Operator recorded with the engagement
+	ProvenanceKey []byte            // Key the provenance record is signed with, optional
+	Provenance    *ProvenanceRecord // Record to embed as is instead of a new one (verify repro)
+	AuditLog      string            // Audit log every run is appended to, none if empty
+
+	ToolchainDir    string // Toolchain cache, see ToolCache
+	StrictToolchain bool   // Require the exact protoc tools the tree was generated with
+	Reproducible    bool   // Pin timestamps, paths and build IDs for byte-identical output
 }
 
-// NewConfig sets up the run directory and repo targets
-func NewConfig(targetVersion string) (*Config, error) {
+// NewConfig sets up the run directory under outputDir and repo targets
+func NewConfig(targetVersion, outputDir string) (*Config, error) {
+	if targetVersion == "" || strings.HasPrefix(targetVersion, "-") || strings.ContainsAny(targetVersion, " \t") {
+		return nil, fmt.Errorf("invalid target version: %q", targetVersion)
+	}
+	target, exists := buildTargets[targetVersion]
+	if !exists {
+		target = BuildTarget{Tag: targetVersion, GitRef: targetVersion}
+	}
+
 	// Create unique run directory
 	timestamp := time.Now().Format("20060102_150405")
-	runDir := filepath.Join("/tmp/output", fmt.Sprintf("run_%s_%s", targetVersion, timestamp))
-	if err := os.MkdirAll(runDir, 0755); err != nil {
+	name := strings.NewReplacer("/", "-", string(os.PathSeparator), "-").Replace(targetVersion)
+	runDir, err := createRunDir(outputDir, fmt.Sprintf("run_%s_%s", name, timestamp))
+	if err != nil {
 		return nil, fmt.Errorf("failed to create run directory: %w", err)
 	}
 
-	// Map target version to its configuration
-	targets := map[string]BuildTarget{
-		"1.5": {
-			Tag:         Version1_5,
-			GitRef:      Version1_5,
-			UseGoLegacy: true,
-		},
-		"1.6": {
-			Tag:         Version1_6,
-			GitRef:      Version1_6,
-			UseGoLegacy: false,
-		},
-	}
+	return &Config{
+		RepoURL:   RepoURL,
+		OutputDir: outputDir,
+		RunDir:    runDir,
+		Target:    target,
 
-	target, exists := targets[targetVersion]
-	if !exists {
-		return nil, fmt.Errorf("invalid target version: %s", targetVersion)
+		MakeTargets:  DefaultMakeTargets,
+		Incompatible: IncompatibleSkip,
+		Residuals:    ResidualsWarn,
+		ToolchainDir: envOr("CLOAK_TOOLCHAINS", DefaultToolchainDir),
+	}, nil
+}
+
+// createRunDir creates a new, empty run directory under outputDir. A numeric
+// suffix is added when a run with the same name already exists.
+func createRunDir(outputDir, name string) (string, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", err
 	}
 
-	return &Config{
-		RepoURL: RepoURL,
-		RunDir:  runDir,
-		Target:  target,
-	}, nil
+	runDir := filepath.Join(outputDir, name)
+	for i := 2; ; i++ {
+		err := os.Mkdir(runDir, 0755)
+		if err == nil {
+			return runDir, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		runDir = filepath.Join(outputDir, fmt.Sprintf("%s_%d", name, i))
+	}
 }
 
 func (b *Builder) cloneRepo() error {
 	// Clone into the run directory
-	cmd := exec.Command("git", "clone", b.config.RepoURL)
+	cmd := exec.Command("git", "clone", b.config.RepoURL, "sliver")
 	cmd.Dir = b.config.RunDir
 	if b.verbose {
 		cmd.Stdout = os.Stdout
@@ -68,33 +124,83 @@ func (b *Builder) cloneRepo() error {
 		return fmt.Errorf("failed to clone repository: %w", err)
 	}
 
-	// Handle tag checkout for 1.5
-	if b.config.Target.UseGoLegacy {
-		repoDir := filepath.Join(b.config.RunDir, "sliver")
+	repoDir := filepath.Join(b.config.RunDir, "sliver")
 
-		cmd = exec.Command("git", "fetch", "--all", "--tags")
-		cmd.Dir = repoDir
-		if b.verbose {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		}
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to fetch tags: %w", err)
-		}
+	// Make sure every tag is available, then check out the target ref
+	cmd = exec.Command("git", "fetch", "--all", "--tags")
+	cmd.Dir = repoDir
+	if b.verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to fetch tags: %w", err)
+	}
+
+	cmd = exec.Command("git", "checkout", b.config.Target.GitRef)
+	cmd.Dir = repoDir
+	if b.verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", b.config.Target.GitRef, err)
+	}
+	if b.verbose {
+		log.Printf("Successfully checked out %s", b.config.Target.GitRef)
+	}
 
-		cmd = exec.Command("git", "checkout", "tags/"+b.config.Target.Tag)
+	// Pin an exact commit, e.g. when reproducing an earlier run
+	if b.config.Target.Commit != "" {
+		cmd = exec.Command("git", "checkout", b.config.Target.Commit)
 		cmd.Dir = repoDir
 		if b.verbose {
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 		}
 		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to checkout tag: %w", err)
-		}
-		if b.verbose {
-			log.Printf("Successfully checked out tag %s", b.config.Target.Tag)
+			return fmt.Errorf("failed to checkout commit %s: %w", b.config.Target.Commit, err)
 		}
 	}
 
 	return nil
 }
+
+// resolveCommit returns the commit hash checked out in the cloned repository
+func (b *Builder) resolveCommit() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = filepath.Join(b.config.RunDir, "sliver")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve commit: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// setupToolchain detects the tool versions the cloned tree needs and selects
+// them from the toolchain cache or the PATH. It runs before any module so a
+// missing toolchain fails the run early.
+func (b *Builder) setupToolchain() error {
+	req, err := detectRequirements(filepath.Join(b.config.RunDir, "sliver"))
+	if err != nil {
+		return err
+	}
+	b.meta.Requirements = req
+
+	cache := NewToolCache(b.config.ToolchainDir)
+	tc, err := resolveToolchain(req, cache, os.Environ(), b.config.StrictToolchain)
+	if err != nil {
+		return err
+	}
+	tc = mergeToolchain(tc, b.config.Target.Toolchain)
+
+	// Fail now rather than after the modules ran if a pinned tool is missing
+	if _, err := cache.Env(tc, os.Environ()); err != nil {
+		return err
+	}
+
+	b.config.Target.Toolchain = tc
+	b.meta.Toolchain = tc
+	log.Printf("Toolchain: go %s (go.mod requires %s)", tc.Go, req.GoDirective)
+	return writeRunMeta(b.meta)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// Hook stages, in the order Builder.Run reaches them
+const (
+	StagePreClone  = "pre-clone"
+	StagePostClone = "post-clone"
+	StagePreBuild  = "pre-build"
+	StagePostBuild = "post-build"
+)
+
+// PreCloneHook is implemented by modules that act before the repository is
+// cloned, e.g. to check prerequisites. The source tree does not exist yet.
+type PreCloneHook interface {
+	PreClone(config *Config, verbose bool) error
+}
+
+// PostCloneHook is implemented by modules that act on the fresh checkout,
+// before any module's Run
+type PostCloneHook interface {
+	PostClone(config *Config, verbose bool) error
+}
+
+// PreBuildHook is implemented by modules that prepare the make step after
+// all modules ran, e.g. by adding to Config.BuildEnv or Config.LDFlags
+type PreBuildHook interface {
+	PreBuild(config *Config, verbose bool) error
+}
+
+// PostBuildHook is implemented by modules that process the build output.
+// It receives the artifacts copied to the run's artifacts directory and
+// returns the artifacts of the run, so hooks can rename, add or drop files.
+// Sizes and hashes are recomputed afterwards.
+type PostBuildHook interface {
+	PostBuild(config *Config, artifacts []Artifact, verbose bool) ([]Artifact, error)
+}
+
+// runHooks calls the hook for stage of every selected module that
+// implements it, in module order. hook returns nil for modules without it.
+func (b *Builder) runHooks(stage string, moduleNames []string, hook func(m Module) func() error) error {
+	for _, name := range moduleNames {
+		call := hook(b.modules[name])
+		if call == nil {
+			continue
+		}
+
+		log.Printf("Running %s hook: %s", stage, name)
+		if err := call(); err != nil {
+			return fmt.Errorf("module %s %s hook failed: %w", name, stage, err)
+		}
+		b.meta.Hooks = append(b.meta.Hooks, stage+":"+name)
+	}
+	return nil
+}
+
+// preClone runs the pre-clone hooks
+func (b *Builder) preClone(moduleNames []string) error {
+	return b.runHooks(StagePreClone, moduleNames, func(m Module) func() error {
+		if h, ok := m.(PreCloneHook); ok {
+			return func() error { return h.PreClone(b.config, b.verbose) }
+		}
+		return nil
+	})
+}
+
+// postClone runs the post-clone hooks
+func (b *Builder) postClone(moduleNames []string) error {
+	return b.runHooks(StagePostClone, moduleNames, func(m Module) func() error {
+		if h, ok := m.(PostCloneHook); ok {
+			return func() error { return h.PostClone(b.config, b.verbose) }
+		}
+		return nil
+	})
+}
+
+// preBuild runs the pre-build hooks
+func (b *Builder) preBuild(moduleNames []string) error {
+	return b.runHooks(StagePreBuild, moduleNames, func(m Module) func() error {
+		if h, ok := m.(PreBuildHook); ok {
+			return func() error { return h.PreBuild(b.config, b.verbose) }
+		}
+		return nil
+	})
+}
+
+// postBuild runs the post-build hooks, threading the artifact list through
+// them, and rehashes the resulting artifacts
+func (b *Builder) postBuild(moduleNames []string, artifacts []Artifact) ([]Artifact, error) {
+	ran := false
+	err := b.runHooks(StagePostBuild, moduleNames, func(m Module) func() error {
+		if h, ok := m.(PostBuildHook); ok {
+			return func() error {
+				var err error
+				artifacts, err = h.PostBuild(b.config, artifacts, b.verbose)
+				ran = true
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || !ran {
+		return artifacts, err
+	}
+
+	for i := range artifacts {
+		size, sum, err := hashFile(filepath.Join(b.config.RunDir, artifacts[i].Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash artifact %s: %w", artifacts[i].Name, err)
+		}
+		artifacts[i].Size = size
+		artifacts[i].SHA256 = sum
+	}
+	return artifacts, nil
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IOCsDir is the directory inside a run that export-iocs writes to by default
+const IOCsDir = "iocs"
+
+// Files of an IOC bundle
+const (
+	iocsJSONFile    = "iocs.json"
+	iocsHashesFile  = "hashes.txt" // sha256sum format
+	iocsRenamesFile = "renames.csv"
+	iocsStringsFile = "strings.txt"
+	iocsRulesFile   = "rules.yar"
+)
+
+// Limits for notable strings. Shorter replacement names and strings are too
+// common to identify a build.
+const (
+	minIOCTermLength   = 4
+	minIOCStringLength = 8
+	maxIOCStringLength = 96
+)
+
+// iocStringByte is a byte of the identifiers, import paths and file names
+// that notable strings are cut from
+var iocStringByte = regexp.MustCompile(`^[A-Za-z0-9_./-]$`)
+
+// ArtifactHashes identifies a built binary
+type ArtifactHashes struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+// PathRename is a file or directory renamed by a module
+type PathRename struct {
+	Module string `json:"module"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// IOCString is a string unique to the customized build
+type IOCString struct {
+	Value     string   `json:"value"`
+	Artifacts []string `json:"artifacts"` // artifacts containing it
+}
+
+// IOCBundle is everything a client's defenders get to detect a run's
+// binaries and the changes made to them
+type IOCBundle struct {
+	Run        string                   `json:"run"`
+	Engagement string                   `json:"engagement,omitempty"`
+	Target     string                   `json:"target"`
+	Commit     string                   `json:"commit"`
+	Generated  time.Time                `json:"generated"`
+	Artifacts  []ArtifactHashes         `json:"artifacts"`
+	Renames    map[string][]Replacement `json:"renames,omitempty"` // module -> replacements
+	Paths      []PathRename             `json:"paths,omitempty"`
+	Strings    []IOCString              `json:"strings,omitempty"`
+	Provenance string                   `json:"provenance,omitempty"` // embedded record, see cloak identify
+}
+
+// buildIOCBundle collects the indicators of a finished run, with at most
+// maxStrings notable strings
+func buildIOCBundle(run *RunMeta, maxStrings int) (*IOCBundle, error) {
+	bundle := &IOCBundle{
+		Run:       run.ID,
+		Target:    run.Target,
+		Commit:    run.Commit,
+		Generated: time.Now().UTC(),
+		Renames:   run.Renames,
+	}
+	if run.Provenance != nil {
+		bundle.Engagement = run.Provenance.Engagement
+		bundle.Provenance = run.Provenance.Record
+	}
+
+	for _, change := range run.Changes {
+		bundle.Paths = append(bundle.Paths, pathRenames(change, run.Renames[change.Module])...)
+	}
+
+	contents := make(map[string][]byte)
+	for _, artifact := range run.Artifacts {
+		data, err := os.ReadFile(filepath.Join(run.Dir(), artifact.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifact %s: %w", artifact.Name, err)
+		}
+		hashes := hashBytes(data)
+		if hashes.SHA256 != artifact.SHA256 {
+			return nil, fmt.Errorf("artifact %s changed since the build (sha256 %s, recorded %s)", artifact.Name, hashes.SHA256, artifact.SHA256)
+		}
+		hashes.Name = artifact.Name
+		bundle.Artifacts = append(bundle.Artifacts, hashes)
+		contents[artifact.Name] = data
+	}
+
+	var terms []string
+	for _, replacements := range run.Renames {
+		for _, r := range replacements {
+			if len(r.Replace) >= minIOCTermLength {
+				terms = append(terms, r.Replace)
+			}
+		}
+	}
+	bundle.Strings = notableStrings(contents, terms, maxStrings)
+	return bundle, nil
+}
+
+// pathRenames returns the files a module renamed. git only reports a rename
+// when the content stayed similar, so a deleted file is also paired with
+// the added file its path turns into under the module's replacements.
+func pathRenames(change ModuleChange, replacements []Replacement) []PathRename {
+	added := make(map[string]bool)
+	for _, file := range change.Files {
+		if file.Status == "A" {
+			added[file.Path] = true
+		}
+	}
+
+	var renames []PathRename
+	for _, file := range change.Files {
+		switch file.Status {
+		case "R":
+			renames = append(renames, PathRename{Module: change.Module, From: file.OldPath, To: file.Path})
+		case "D":
+			to := file.Path
+			for _, r := range replacements {
+				to = strings.ReplaceAll(to, r.Search, r.Replace)
+			}
+			if to != file.Path && added[to] {
+				renames = append(renames, PathRename{Module: change.Module, From: file.Path, To: to})
+			}
+		}
+	}
+	return renames
+}
+
+// hashBytes returns the MD5, SHA-1 and SHA-256 digests of data
+func hashBytes(data []byte) ArtifactHashes {
+	md5Sum := md5.Sum(data)
+	sha1Sum := sha1.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	return ArtifactHashes{
+		Size:   int64(len(data)),
+		MD5:    hex.EncodeToString(md5Sum[:]),
+		SHA1:   hex.EncodeToString(sha1Sum[:]),
+		SHA256: hex.EncodeToString(sha256Sum[:]),
+	}
+}
+
+// notableStrings returns the strings around the replacement terms in the
+// artifacts, e.g. renamed import paths and symbols, which only a build with
+// these replacements contains. Strings found in more artifacts come first,
+// then longer ones. Runs that reach maxIOCStringLength are left out: Go
+// packs string data without separators, so they span unrelated strings.
+func notableStrings(contents map[string][]byte, terms []string, max int) []IOCString {
+	found := make(map[string]map[string]bool) // string -> artifacts
+	for name, data := range contents {
+		for _, term := range terms {
+			for offset := 0; ; {
+				i := bytes.Index(data[offset:], []byte(term))
+				if i < 0 {
+					break
+				}
+				start, end := offset+i, offset+i+len(term)
+				offset = end
+				for start > 0 && end-start < maxIOCStringLength && iocStringByte.Match(data[start-1:start]) {
+					start--
+				}
+				for end < len(data) && end-start < maxIOCStringLength && iocStringByte.Match(data[end:end+1]) {
+					end++
+				}
+				if end-start < minIOCStringLength || end-start >= maxIOCStringLength {
+					continue
+				}
+				value := string(data[start:end])
+				if found[value] == nil {
+					found[value] = make(map[string]bool)
+				}
+				found[value][name] = true
+			}
+		}
+	}
+
+	strs := make([]IOCString, 0, len(found))
+	for value, artifacts := range found {
+		s := IOCString{Value: value}
+		for name := range artifacts {
+			s.Artifacts = append(s.Artifacts, name)
+		}
+		sort.Strings(s.Artifacts)
+		strs = append(strs, s)
+	}
+	sort.Slice(strs, func(i, j int) bool {
+		a, b := strs[i], strs[j]
+		if len(a.Artifacts) != len(b.Artifacts) {
+			return len(a.Artifacts) > len(b.Artifacts)
+		}
+		if len(a.Value) != len(b.Value) {
+			return len(a.Value) > len(b.Value)
+		}
+		return a.Value < b.Value
+	})
+	if len(strs) > max {
+		strs = strs[:max]
+	}
+	return strs
+}
+
+// yaraIdentifier turns s into a valid YARA identifier part
+func yaraIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// yaraString quotes s as a YARA text string
+func yaraString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// writeYARA writes the rules matching a bundle: the exact artifacts by
+// hash, customized builds by their notable strings, and the embedded
+// provenance record
+func writeYARA(w io.Writer, bundle *IOCBundle) error {
+	prefix := "cloak_" + yaraIdentifier(bundle.Run)
+	var b strings.Builder
+	meta := func(description string) {
+		b.WriteString("\tmeta:\n")
+		fmt.Fprintf(&b, "\t\tdescription = %s\n", yaraString(description))
+		fmt.Fprintf(&b, "\t\trun = %s\n", yaraString(bundle.Run))
+		if bundle.Engagement != "" {
+			fmt.Fprintf(&b, "\t\tengagement = %s\n", yaraString(bundle.Engagement))
+		}
+		fmt.Fprintf(&b, "\t\tcommit = %s\n", yaraString(bundle.Commit))
+		fmt.Fprintf(&b, "\t\tdate = %s\n", yaraString(bundle.Generated.Format("2006-01-02")))
+	}
+
+	fmt.Fprintf(&b, "// YARA rules for cloak run %s, generated by cloak export-iocs\n\n", bundle.Run)
+	b.WriteString("import \"hash\"\n")
+
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, artifact := range bundle.Artifacts {
+		if !seen[artifact.SHA256] {
+			seen[artifact.SHA256] = true
+			hashes = append(hashes, fmt.Sprintf("hash.sha256(0, filesize) == %s", yaraString(artifact.SHA256)))
+		}
+	}
+	if len(hashes) > 0 {
+		fmt.Fprintf(&b, "\nrule %s_artifacts\n{\n", prefix)
+		meta("Binaries built by the run")
+		fmt.Fprintf(&b, "\tcondition:\n\t\t%s\n}\n", strings.Join(hashes, " or\n\t\t"))
+	}
+
+	if len(bundle.Strings) > 0 {
+		fmt.Fprintf(&b, "\nrule %s_strings\n{\n", prefix)
+		meta("Builds customized with the run's replacement names")
+		b.WriteString("\tstrings:\n")
+		for i, s := range bundle.Strings {
+			fmt.Fprintf(&b, "\t\t$s%d = %s ascii wide\n", i, yaraString(s.Value))
+		}
+		matches := len(bundle.Strings)
+		if matches > 3 {
+			matches = 3
+		}
+		fmt.Fprintf(&b, "\tcondition:\n\t\t%d of ($s*)\n}\n", matches)
+	}
+
+	if bundle.Provenance != "" {
+		fmt.Fprintf(&b, "\nrule %s_provenance\n{\n", prefix)
+		meta("Provenance record embedded by the run")
+		fmt.Fprintf(&b, "\tstrings:\n\t\t$p = %s ascii\n", yaraString(bundle.Provenance))
+		b.WriteString("\tcondition:\n\t\t$p\n}\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeIOCBundle writes the bundle files to dir
+func writeIOCBundle(dir string, bundle *IOCBundle) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	files := map[string][]byte{iocsJSONFile: append(data, '\n')}
+
+	var hashes bytes.Buffer
+	for _, artifact := range bundle.Artifacts {
+		fmt.Fprintf(&hashes, "%s  %s\n", artifact.SHA256, artifact.Name)
+	}
+	files[iocsHashesFile] = hashes.Bytes()
+
+	var renames bytes.Buffer
+	cw := csv.NewWriter(&renames)
+	cw.Write([]string{"kind", "module", "original", "replacement"})
+	modules := make([]string, 0, len(bundle.Renames))
+	for module := range bundle.Renames {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		for _, r := range bundle.Renames[module] {
+			cw.Write([]string{"term", module, r.Search, r.Replace})
+		}
+	}
+	for _, p := range bundle.Paths {
+		cw.Write([]string{"path", p.Module, p.From, p.To})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	files[iocsRenamesFile] = renames.Bytes()
+
+	var strs bytes.Buffer
+	for _, s := range bundle.Strings {
+		strs.WriteString(s.Value + "\n")
+	}
+	files[iocsStringsFile] = strs.Bytes()
+
+	var rules bytes.Buffer
+	if err := writeYARA(&rules, bundle); err != nil {
+		return err
+	}
+	files[iocsRulesFile] = rules.Bytes()
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// writeTarGz archives the files of dir, without subdirectories, under a
+// top-level directory named after the archive
+func writeTarGz(path, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	base := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Name:    base + "/" + entry.Name(),
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return writeFileFrom(path, &buf, 0644)
+}
+
+// exportIOCsCommand implements 'cloak export-iocs <run>'
+func exportIOCsCommand(args []string) error {
+	fs := newFlagSet("export-iocs", "[flags] <run>", "Write the indicators of a run for the client's defenders: artifact hashes, the rename map, notable strings and YARA rules.")
+	outputDir := outputFlag(fs)
+	dir := fs.String("dir", "", "Bundle directory (default <run>/"+IOCsDir+")")
+	archive := fs.String("archive", "", "Also pack the bundle into this .tar.gz file")
+	maxStrings := fs.Int("strings", 20, "Maximum number of notable strings")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: cloak export-iocs [flags] <run>")
+	}
+	if *maxStrings < 0 {
+		return fmt.Errorf("invalid -strings %d, expected 0 or more", *maxStrings)
+	}
+
+	run, err := findRun(*outputDir, positional[0])
+	if err != nil {
+		return err
+	}
+	if run.Status != RunStatusSuccess {
+		return fmt.Errorf("run %s did not succeed (status %s)", run.ID, run.Status)
+	}
+	if len(run.Modules) > 0 && run.Renames == nil {
+		log.Printf("Warning: run %s does not record module replacements, only renamed paths are exported", run.ID)
+	}
+
+	bundle, err := buildIOCBundle(run, *maxStrings)
+	if err != nil {
+		return err
+	}
+	if *dir == "" {
+		*dir = filepath.Join(run.Dir(), IOCsDir)
+	}
+	if err := writeIOCBundle(*dir, bundle); err != nil {
+		return err
+	}
+	log.Printf("Exported %d artifacts, %d renamed paths and %d strings to %s", len(bundle.Artifacts), len(bundle.Paths), len(bundle.Strings), *dir)
+
+	if *archive != "" {
+		if err := writeTarGz(*archive, *dir); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		log.Println("Archive:", *archive)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotableStrings(t *testing.T) {
+	packed := strings.Repeat("int16int32", 10) + "gunner" + strings.Repeat("uint8", 20)
+	contents := map[string][]byte{
+		"gunner-server": []byte("\x00github.com/knightbruce/gunner/server\x00gunnerpb.Frank\x00" + packed + "\x00"),
+		"gunner-client": []byte("\x01github.com/knightbruce/gunner/client\x00gunnerpb.Frank\x00ab.gunner\x00"),
+	}
+	strs := notableStrings(contents, []string{"gunner", "knightbruce"}, 3)
+
+	var values []string
+	for _, s := range strs {
+		values = append(values, s.Value)
+	}
+	want := []string{"gunnerpb.Frank", "github.com/knightbruce/gunner/client", "github.com/knightbruce/gunner/server"}
+	if !equalStrings(values, want) {
+		t.Errorf("strings = %q, want %q", values, want)
+	}
+	if !equalStrings(strs[0].Artifacts, []string{"gunner-client", "gunner-server"}) {
+		t.Errorf("artifacts of %s = %v", strs[0].Value, strs[0].Artifacts)
+	}
+}
+
+func TestExportIOCsCommand(t *testing.T) {
+	err := exportIOCsCommand([]string{"-output", t.TempDir(), "-strings", "-1", "run_1.5_1"})
+	if err == nil || !strings.Contains(err.Error(), "-strings") {
+		t.Errorf("negative -strings: %v", err)
+	}
+
+	// -strings 0 leaves the notable strings out
+	runDir := t.TempDir()
+	data := []byte("\x00github.com/knightbruce/gunner/server\x00gunnerpb.Frank\x00")
+	if err := os.WriteFile(filepath.Join(runDir, "gunner-server"), data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run := &RunMeta{
+		ID:        "run_1.5_1",
+		Status:    RunStatusSuccess,
+		Artifacts: []Artifact{{Name: "gunner-server", Path: "gunner-server", SHA256: hashBytes(data).SHA256}},
+		Renames:   map[string][]Replacement{"branding": {{Search: "sliver", Replace: "gunner"}}},
+		dir:       runDir,
+	}
+	bundle, err := buildIOCBundle(run, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Strings) != 0 {
+		t.Errorf("strings with -strings 0 = %v", bundle.Strings)
+	}
+	if len(bundle.Artifacts) != 1 {
+		t.Errorf("artifacts = %v", bundle.Artifacts)
+	}
+}
+
+func TestPathRenames(t *testing.T) {
+	change := ModuleChange{Module: "branding", Files: []FileChange{
+		{Status: "R", OldPath: "protobuf/sliverpb/sliver.proto", Path: "protobuf/gunnerpb/gunner.proto"},
+		{Status: "D", Path: "client/sliver/sliver.go"},
+		{Status: "A", Path: "client/gunner/gunner.go"},
+		{Status: "D", Path: "server/old.go"},
+		{Status: "A", Path: "server/new.go"},
+		{Status: "M", Path: "go.mod"},
+	}}
+	renames := pathRenames(change, []Replacement{{Search: "sliver", Replace: "gunner"}})
+	want := []PathRename{
+		{Module: "branding", From: "protobuf/sliverpb/sliver.proto", To: "protobuf/gunnerpb/gunner.proto"},
+		{Module: "branding", From: "client/sliver/sliver.go", To: "client/gunner/gunner.go"},
+	}
+	if len(renames) != len(want) {
+		t.Fatalf("renames = %v, want %v", renames, want)
+	}
+	for i := range want {
+		if renames[i] != want[i] {
+			t.Errorf("rename %d = %v, want %v", i, renames[i], want[i])
+		}
+	}
+}
+
+func TestWriteYARA(t *testing.T) {
+	bundle := &IOCBundle{
+		Run:        "run_1.6_20250111_210029",
+		Engagement: `ACME "red"`,
+		Commit:     "abc123",
+		Generated:  time.Date(2025, 1, 12, 0, 0, 0, 0, time.UTC),
+		Artifacts: []ArtifactHashes{
+			{Name: "gunner-server", SHA256: "aa"},
+			{Name: "gunner-client", SHA256: "aa"},
+			{Name: "gunner-server.exe", SHA256: "bb"},
+		},
+		Strings: []IOCString{{Value: "gunnerpb.Frank"}, {Value: `C:\gunner`}},
+	}
+	var b strings.Builder
+	if err := writeYARA(&b, bundle); err != nil {
+		t.Fatal(err)
+	}
+	rules := b.String()
+
+	for _, want := range []string{
+		"rule cloak_run_1_6_20250111_210029_artifacts\n",
+		`engagement = "ACME \"red\""`,
+		`hash.sha256(0, filesize) == "aa" or` + "\n\t\t" + `hash.sha256(0, filesize) == "bb"` + "\n}",
+		`$s1 = "C:\\gunner" ascii wide`,
+		"2 of ($s*)",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules do not contain %q:\n%s", want, rules)
+		}
+	}
+	if strings.Contains(rules, "_provenance") {
+		t.Errorf("provenance rule without a record:\n%s", rules)
+	}
+}
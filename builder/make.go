@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 func (b *Builder) runMake() error {
@@ -13,26 +15,68 @@ func (b *Builder) runMake() error {
 		return fmt.Errorf("make directory not found: %w", err)
 	}
 
-	// First run 'make pb'
-	cmd := exec.Command("make", "pb")
-	cmd.Dir = makeDir
+	// Select the target's toolchain without touching the global install
+	env, err := NewToolCache(b.config.ToolchainDir).Env(b.config.Target.Toolchain, os.Environ())
+	if err != nil {
+		return fmt.Errorf("toolchain setup failed: %w", err)
+	}
 	if b.verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		if version, err := goVersion(env); err == nil {
+			log.Printf("Using %s", version)
+		}
 	}
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("make pb failed: %w", err)
+
+	// Overrides from the profile and flags, then environment added by
+	// pre-build hooks
+	env = b.config.BuildVars.environ(env)
+	for _, kv := range b.config.BuildEnv {
+		key, value, found := strings.Cut(kv, "=")
+		if !found || key == "" {
+			return fmt.Errorf("invalid build environment entry %q", kv)
+		}
+		env = setEnv(env, key, value)
 	}
 
-	// Then run 'make'
-	cmd = exec.Command("make")
-	cmd.Dir = makeDir
-	if b.verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	var vars []string
+	ldflags := append(b.config.BuildVars.ldflags(), b.config.LDFlags...)
+	if b.config.Reproducible {
+		env, vars, err = b.setupReproducible(env)
+		if err != nil {
+			return fmt.Errorf("reproducible build setup failed: %w", err)
+		}
+		ldflags = append(ldflags, "-buildid=")
 	}
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("make failed: %w", err)
+	if len(ldflags) > 0 {
+		assignment, err := b.appendLDFlags(makeDir, env, vars, strings.Join(ldflags, " "))
+		if err != nil {
+			return err
+		}
+		vars = append(vars, assignment)
+	}
+	if tags := b.config.BuildVars.Tags; len(tags) > 0 {
+		assignment, err := b.appendTags(makeDir, env, vars, tags)
+		if err != nil {
+			return err
+		}
+		vars = append(vars, assignment)
+	}
+
+	for _, target := range b.config.MakeTargets {
+		cmd := exec.Command("make", append([]string{target}, vars...)...)
+		cmd.Dir = makeDir
+		cmd.Env = env
+		b.meta.Make = append(b.meta.Make, commandLine(env, cmd.Args))
+		if err := writeRunMeta(b.meta); err != nil {
+			return err
+		}
+		if b.verbose {
+			log.Println("Running make", target)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+		}
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("make %s failed: %w", target, err)
+		}
 	}
 
 	return nil
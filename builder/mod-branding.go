@@ -1,36 +1,72 @@
 package main
 
 import (
+	"cloak/pkg/names"
 	"cloak/pkg/subs"
 	"fmt"
+	"log"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
+// brandingName matches replacement names, which end up in Go package names
+// and file paths
+var brandingName = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
 type SearchReplacePair struct {
 	search  string
 	replace string
 }
 
+// nameGenerator returns the generator for a module's random names, seeded
+// from the run and reserving the identifiers already in the source tree
+func nameGenerator(config *Config, module, wordlist string) (*names.Generator, error) {
+	g := names.New(config.Seed, module)
+	if wordlist != "" {
+		if err := g.LoadWords(wordlist); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.ReserveTree(filepath.Join(config.RunDir, "sliver")); err != nil {
+		return nil, fmt.Errorf("failed to collect identifiers: %w", err)
+	}
+	return g, nil
+}
+
 type BrandingModule struct {
 	ignoreList   []string
 	renamePaths  bool
+	symlinks     subs.SymlinkPolicy
+	random       bool
+	wordlist     string
 	replacePairs []SearchReplacePair
 }
 
 func NewBrandingModule() *BrandingModule {
 	return &BrandingModule{
-		ignoreList:  []string{".git", ".github", "docs", "vendor"},
-		renamePaths: true,
-		replacePairs: []SearchReplacePair{
-			{search: "sliver", replace: "gunner"},
-			{search: "Sliver", replace: "Gunner"},
-			{search: "SLIVER", replace: "GUNNER"},
-			{search: "beacon", replace: "lazer"},
-			{search: "Beacon", replace: "Lazer"},
-			{search: "BEACON", replace: "LAZER"},
-			{search: "bishopfox", replace: "knightbruce"},
-			{search: "BishopFox", replace: "KnightBruce"},
-		},
+		ignoreList:   []string{".git", ".github", "docs", "vendor"},
+		renamePaths:  true,
+		symlinks:     subs.SymlinkSkip,
+		replacePairs: brandingPairs("gunner", "lazer", "KnightBruce"),
+	}
+}
+
+// brandingPairs returns the replacements for every casing of the Sliver,
+// beacon and Bishop Fox names
+func brandingPairs(prefix, beacon, vendor string) []SearchReplacePair {
+	title := func(s string) string {
+		return strings.ToUpper(s[:1]) + s[1:]
+	}
+	return []SearchReplacePair{
+		{search: "sliver", replace: prefix},
+		{search: "Sliver", replace: title(prefix)},
+		{search: "SLIVER", replace: strings.ToUpper(prefix)},
+		{search: "beacon", replace: beacon},
+		{search: "Beacon", replace: title(beacon)},
+		{search: "BEACON", replace: strings.ToUpper(beacon)},
+		{search: "bishopfox", replace: strings.ToLower(vendor)},
+		{search: "BishopFox", replace: vendor},
 	}
 }
 
@@ -38,27 +74,95 @@ func (m *BrandingModule) Name() string {
 	return "branding"
 }
 
+func (m *BrandingModule) Description() string {
+	return "Renames Sliver, beacon and Bishop Fox branding in file contents, file names and directories"
+}
+
+func (m *BrandingModule) Params() []Param {
+	return []Param{
+		{Name: "prefix", Default: "gunner", Description: "Replacement for sliver, in the same casing"},
+		{Name: "beacon", Default: "lazer", Description: "Replacement for beacon, in the same casing"},
+		{Name: "vendor", Default: "KnightBruce", Description: "Replacement for BishopFox (lowercased for bishopfox)"},
+		{Name: "ignore", Type: ParamList, Default: ".git,.github,docs,vendor", Description: "Directories to leave untouched"},
+		{Name: "rename-paths", Type: ParamBool, Default: "true", Description: "Also rename matching files and directories"},
+		{Name: "symlinks", Default: "skip", Choices: []string{"skip", "follow", "retarget"}, Description: "Symlinks: skip, follow (rewrite the file they point to) or retarget (rewrite the link path)"},
+		{Name: "random", Type: ParamBool, Default: "false", Description: "Generate the prefix, beacon and vendor names from the run seed"},
+		{Name: "wordlist", Description: "File with one word per line to draw random names from, instead of syllables"},
+	}
+}
+
+func (m *BrandingModule) Configure(values ParamValues) error {
+	prefix, beacon, vendor := values.String("prefix"), values.String("beacon"), values.String("vendor")
+	for _, name := range []string{prefix, beacon, strings.ToLower(vendor)} {
+		if !brandingName.MatchString(name) {
+			return fmt.Errorf("invalid name %q: use lowercase letters and digits, starting with a letter", name)
+		}
+	}
+	if prefix == "sliver" || beacon == "beacon" || strings.ToLower(vendor) == "bishopfox" {
+		return fmt.Errorf("replacement names must differ from the original names")
+	}
+
+	m.replacePairs = brandingPairs(prefix, beacon, vendor)
+	m.ignoreList = values.List("ignore")
+	m.renamePaths = values.Bool("rename-paths")
+	symlinks, err := subs.ParseSymlinkPolicy(values.String("symlinks"))
+	if err != nil {
+		return err
+	}
+	m.symlinks = symlinks
+	m.random = values.Bool("random")
+	m.wordlist = values.String("wordlist")
+	return nil
+}
+
+func (m *BrandingModule) SearchTerms() []string {
+	terms := make([]string, len(m.replacePairs))
+	for i, pair := range m.replacePairs {
+		terms[i] = pair.search
+	}
+	return terms
+}
+
+// Replacements returns the pairs applied by Run, including generated names
+func (m *BrandingModule) Replacements() []Replacement {
+	return pairReplacements(m.replacePairs)
+}
+
 func (m *BrandingModule) Run(config *Config, verbose bool) error {
-	startPath := filepath.Join(config.RunDir, "sliver")
+	fsys := subs.NewOSFS(filepath.Join(config.RunDir, "sliver"))
+
+	if m.random {
+		g, err := nameGenerator(config, m.Name(), m.wordlist)
+		if err != nil {
+			return err
+		}
+		prefix, beacon, vendor := g.Word(), g.Word(), g.Like("BishopFox")
+		m.replacePairs = brandingPairs(prefix, beacon, vendor)
+		log.Printf("[branding] names from seed %d: prefix %s, beacon %s, vendor %s", config.Seed, prefix, beacon, vendor)
+	}
 
 	// Start the recursive search and replace
 	var err error
 	for _, pair := range m.replacePairs {
-
-		err = subs.SearchAndReplace(startPath, pair.search, pair.replace, m.ignoreList, verbose)
+		err = subs.SearchAndReplaceLinks(fsys, pair.search, pair.replace, m.ignoreList, m.symlinks, verbose)
 		if err != nil {
 			return fmt.Errorf("[branding] [SearchAndReplace] error during execution: %v", err)
 		}
+	}
 
-		err = subs.SearchAndRenameFiles(startPath, pair.search, pair.replace, m.ignoreList, verbose)
-		if err != nil {
-			return fmt.Errorf("[branding] [SearchAndRenameFiles] error during execution: %v", err)
-		}
+	if !m.renamePaths {
+		return nil
+	}
 
-		err = subs.SearchAndRenameDirectories(startPath, pair.search, pair.replace, m.ignoreList, verbose)
-		if err != nil {
-			return fmt.Errorf("[branding] [SearchAndRenameDirectories] error during execution: %v", err)
-		}
+	// Plan the renames for all pairs at once, so collisions are reported
+	// before anything is moved
+	rules := make([]subs.Rule, len(m.replacePairs))
+	for i, pair := range m.replacePairs {
+		rules[i] = subs.Rule{Search: pair.search, Replace: pair.replace}
+	}
+	err = subs.RenamePaths(fsys, rules, m.ignoreList, verbose)
+	if err != nil {
+		return fmt.Errorf("[branding] [RenamePaths] error during execution: %v", err)
 	}
 
 	return nil
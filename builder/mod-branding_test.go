@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+func TestBrandingModule(t *testing.T) {
+	src := runModule(t, NewBrandingModule(), nil)
+	moduletest.CompareGolden(t, src, filepath.Join("testdata", "golden", "branding"))
+
+	moduletest.AssertRenamed(t, src, "client/sliver", "client/gunner")
+	moduletest.AssertRenamed(t, src, "implant/sliver/beacon.go", "implant/gunner/lazer.go")
+	moduletest.AssertRenamed(t, src, "scripts/build-sliver.sh", "scripts/build-gunner.sh")
+	moduletest.AssertMode(t, src, "scripts/build-gunner.sh", 0755)
+	moduletest.AssertCount(t, src, "knightbruce", 2)
+
+	// docs is on the ignore list
+	moduletest.AssertExists(t, src, "docs/sliver.md")
+	moduletest.AssertCount(t, src, "Sliver", 1)
+}
+
+func TestBrandingModuleParams(t *testing.T) {
+	src := runModule(t, NewBrandingModule(), ParamValues{
+		"prefix":       "falcon",
+		"rename-paths": "false",
+	})
+
+	moduletest.AssertExists(t, src, "client/sliver/sliver.go", "implant/sliver/beacon.go")
+	moduletest.AssertCount(t, src, "FalconVersion", 2)
+	moduletest.AssertCount(t, src, "falcon", 7)
+	moduletest.AssertCount(t, src, "lazer", 2)
+}
+
+func TestBrandingModuleRejectsInvalidNames(t *testing.T) {
+	for _, prefix := range []string{"Falcon", "1falcon", "fal-con", "sliver"} {
+		values, err := resolveParams(NewBrandingModule(), ParamValues{"prefix": prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewBrandingModule().Configure(values); err == nil {
+			t.Errorf("prefix %q was accepted", prefix)
+		}
+	}
+}
+
+func TestBrandingModuleRandomNames(t *testing.T) {
+	run := func(seed int64) string {
+		m := NewBrandingModule()
+		values, err := resolveParams(m, ParamValues{"random": "true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Configure(values); err != nil {
+			t.Fatal(err)
+		}
+		return moduletest.Run(t, fixture, func(runDir string) error {
+			return m.Run(&Config{RunDir: runDir, Seed: seed}, false)
+		})
+	}
+
+	first, again, other := run(1234), run(1234), run(5678)
+	files := moduletest.Files(t, first)
+	if !equalStrings(files, moduletest.Files(t, again)) {
+		t.Errorf("same seed produced different trees: %v vs %v", files, moduletest.Files(t, again))
+	}
+	if equalStrings(files, moduletest.Files(t, other)) {
+		t.Errorf("different seeds produced the same tree: %v", files)
+	}
+	moduletest.AssertCount(t, first, "gunner", 0)
+	moduletest.AssertCount(t, first, "sliver", 0)
+	moduletest.AssertCount(t, first, "Sliver", 1) // docs
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
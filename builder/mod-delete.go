@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cloak/pkg/subs"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type DeleteModule struct {
+	patterns  []string
+	root      string
+	mustExist bool
+}
+
+func NewDeleteModule() *DeleteModule {
+	return &DeleteModule{root: "."}
+}
+
+func (m *DeleteModule) Name() string {
+	return "delete"
+}
+
+func (m *DeleteModule) Description() string {
+	return "Removes files and directories matching glob patterns from the source tree"
+}
+
+func (m *DeleteModule) Params() []Param {
+	return []Param{
+		{Name: "paths", Type: ParamList, Description: "Glob patterns relative to the tree, ** matches any number of directories"},
+		{Name: "root", Default: ".", Description: "Directory in the tree that everything deleted must be under"},
+		{Name: "must-exist", Type: ParamBool, Default: "false", Description: "Fail if a pattern matches nothing, to catch upstream changes"},
+	}
+}
+
+func (m *DeleteModule) Configure(values ParamValues) error {
+	m.patterns = values.List("paths")
+	for _, pattern := range m.patterns {
+		if err := subs.ValidPattern(pattern); err != nil {
+			return err
+		}
+	}
+	m.root = path.Clean(values.String("root"))
+	if !fs.ValidPath(m.root) || m.root == ".git" || strings.HasPrefix(m.root, ".git/") {
+		return fmt.Errorf("invalid root %q: must be a directory inside the source tree", values.String("root"))
+	}
+	m.mustExist = values.Bool("must-exist")
+	return nil
+}
+
+// inRoot reports whether name is the root or under it
+func (m *DeleteModule) inRoot(name string) bool {
+	return m.root == "." || name == m.root || strings.HasPrefix(name, m.root+"/")
+}
+
+func (m *DeleteModule) Run(config *Config, verbose bool) error {
+	if len(m.patterns) == 0 {
+		log.Println("[delete] no paths given, set delete.paths")
+		return nil
+	}
+	return m.delete(subs.NewOSFS(filepath.Join(config.RunDir, "sliver")), verbose)
+}
+
+// delete removes what the patterns match in fsys
+func (m *DeleteModule) delete(fsys subs.FS, verbose bool) error {
+	// Resolve every pattern before deleting anything, so a failed check
+	// leaves the tree untouched
+	var targets, missing []string
+	seen := make(map[string]bool)
+	for _, pattern := range m.patterns {
+		matches, err := subs.Glob(fsys, pattern)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			missing = append(missing, pattern)
+		}
+		for _, name := range matches {
+			if !m.inRoot(name) {
+				return fmt.Errorf("%s matches %s, which is outside the allowed root %s", pattern, name, m.root)
+			}
+			if !seen[name] {
+				seen[name] = true
+				targets = append(targets, name)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		if m.mustExist {
+			return fmt.Errorf("patterns match nothing: %s", strings.Join(missing, ", "))
+		}
+		log.Printf("[delete] patterns match nothing: %s", strings.Join(missing, ", "))
+	}
+
+	deleted := 0
+	for _, name := range targets {
+		// Already gone with a directory deleted before
+		if _, err := fsys.Lstat(name); err != nil {
+			continue
+		}
+		if err := fsys.RemoveAll(name); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+		deleted++
+		if verbose {
+			log.Printf("[delete] %s", name)
+		}
+	}
+	log.Printf("[delete] deleted %d paths", deleted)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"testing"
+
+	"cloak/pkg/moduletest"
+	"cloak/pkg/subs"
+)
+
+func TestDeleteModule(t *testing.T) {
+	src := runModule(t, NewDeleteModule(), ParamValues{"paths": "docs,**/*.proto,scripts/*.sh"})
+	moduletest.CompareGolden(t, src, filepath.Join("testdata", "golden", "delete"))
+}
+
+func TestDeleteModuleMemFS(t *testing.T) {
+	fsys := subs.NewMemFS()
+	for _, name := range []string{"docs/README.md", "protobuf/sliverpb/sliver.proto", "server/main.go"} {
+		if err := fsys.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsys.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewDeleteModule()
+	values, err := resolveParams(m, ParamValues{"paths": "docs,**/*.proto", "must-exist": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Configure(values); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.delete(fsys, false); err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{"docs": false, "protobuf/sliverpb/sliver.proto": false, "protobuf/sliverpb": true, "server/main.go": true} {
+		if _, err := fsys.Lstat(name); (err == nil) != exists {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, exists)
+		}
+	}
+}
+
+func TestDeleteModuleChecks(t *testing.T) {
+	for name, params := range map[string]ParamValues{
+		"outside root":  {"paths": "docs,server/generate", "root": "server"},
+		"missing":       {"paths": "docs,examples", "must-exist": "true"},
+		"missing glob":  {"paths": "**/*.rs", "must-exist": "true"},
+		"nothing found": {"paths": "server/*.c", "root": "server", "must-exist": "true"},
+	} {
+		m := NewDeleteModule()
+		values, err := resolveParams(m, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Configure(values); err != nil {
+			t.Fatal(err)
+		}
+		runDir := moduletest.Setup(t, fixture)
+		if err := m.Run(&Config{RunDir: runDir}, false); err == nil {
+			t.Errorf("%s: delete succeeded", name)
+		}
+		// Nothing is deleted when a check fails
+		moduletest.AssertSameTree(t, filepath.Join(runDir, moduletest.SourceDir), fixture)
+	}
+
+	for _, params := range []ParamValues{
+		{"paths": "../x"},
+		{"paths": "/etc"},
+		{"paths": "docs", "root": "../"},
+		{"paths": "docs", "root": ".git"},
+	} {
+		m := NewDeleteModule()
+		values, err := resolveParams(m, params)
+		if err == nil {
+			err = m.Configure(values)
+		}
+		if err == nil {
+			t.Errorf("invalid parameters accepted: %v", params)
+		}
+	}
+}
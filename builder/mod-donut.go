@@ -2,18 +2,22 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
 type DoNotAmsiModule struct {
 	generateFtnPath string
+	bypass          int
 }
 
 func NewDoNotAmsiModule() *DoNotAmsiModule {
 	return &DoNotAmsiModule{
 		generateFtnPath: "server/generate/donut.go",
+		bypass:          1,
 	}
 }
 
@@ -21,10 +25,39 @@ func (m *DoNotAmsiModule) Name() string {
 	return "donotamsi"
 }
 
+func (m *DoNotAmsiModule) Description() string {
+	return "Disables donut's AMSI/WLDP bypass in generated shellcode"
+}
+
+func (m *DoNotAmsiModule) Params() []Param {
+	return []Param{
+		{Name: "path", Default: "server/generate/donut.go", Description: "Source file that configures donut, relative to the tree"},
+		{Name: "bypass", Type: ParamInt, Default: "1", Choices: []string{"1", "2", "3"},
+			Description: "Donut AMSI/WLDP bypass: 1 none, 2 abort on failure, 3 continue on failure"},
+	}
+}
+
+func (m *DoNotAmsiModule) Configure(values ParamValues) error {
+	p := path.Clean(values.String("path"))
+	if !fs.ValidPath(p) || p == "." {
+		return fmt.Errorf("invalid path %q: must be a file inside the source tree", values.String("path"))
+	}
+	m.generateFtnPath = p
+	m.bypass = values.Int("bypass")
+	return nil
+}
+
+func (m *DoNotAmsiModule) Compatibility() Compatibility {
+	return Compatibility{
+		Symbols: []Symbol{{File: m.generateFtnPath, Text: "Bypass:     3,"}},
+	}
+}
+
 func (m *DoNotAmsiModule) Run(config *Config, verbose bool) error {
 	// https://github.com/Binject/go-donut/blob/master/main.go#L31
 	// s/Bypass:     3,/Bypass:     1,/g
 	// s/config.Bypass = 3/config.Bypass = 1/g
+	// (or the configured bypass value)
 
 	filePath := filepath.Join(config.RunDir, "sliver", m.generateFtnPath)
 	// Read the entire file
@@ -34,8 +67,8 @@ func (m *DoNotAmsiModule) Run(config *Config, verbose bool) error {
 	}
 
 	// Perform the replacements
-	newContent := strings.ReplaceAll(string(content), "Bypass:     3,", "Bypass:     1,")
-	newContent = strings.ReplaceAll(newContent, "config.Bypass = 3", "config.Bypass = 1")
+	newContent := strings.ReplaceAll(string(content), "Bypass:     3,", fmt.Sprintf("Bypass:     %d,", m.bypass))
+	newContent = strings.ReplaceAll(newContent, "config.Bypass = 3", fmt.Sprintf("config.Bypass = %d", m.bypass))
 
 	// Write the modified content back to the file
 	err = os.WriteFile(filePath, []byte(newContent), 0644)
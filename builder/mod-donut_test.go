@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+func TestDoNotAmsiModule(t *testing.T) {
+	src := runModule(t, NewDoNotAmsiModule(), nil)
+	moduletest.CompareGolden(t, src, filepath.Join("testdata", "golden", "donotamsi"))
+	moduletest.AssertCount(t, src, "Bypass:     1,", 1)
+	moduletest.AssertCount(t, src, "config.Bypass = 1", 1)
+}
+
+func TestDoNotAmsiModuleBypassParam(t *testing.T) {
+	src := runModule(t, NewDoNotAmsiModule(), ParamValues{"bypass": "2"})
+	moduletest.AssertCount(t, src, "Bypass:     2,", 1)
+	moduletest.AssertCount(t, src, "config.Bypass = 2", 1)
+	moduletest.AssertCount(t, src, "Bypass = 3", 0)
+
+	if _, err := resolveParams(NewDoNotAmsiModule(), ParamValues{"bypass": "4"}); err == nil {
+		t.Error("bypass 4 was accepted")
+	}
+
+	for _, p := range []string{"../../x", "/etc/passwd", "server/../../x", "."} {
+		m := NewDoNotAmsiModule()
+		values, err := resolveParams(m, ParamValues{"path": p})
+		if err == nil {
+			err = m.Configure(values)
+		}
+		if err == nil {
+			t.Errorf("path %s was accepted", p)
+		}
+	}
+}
+
+func TestDoNotAmsiModuleCompatibility(t *testing.T) {
+	m := NewDoNotAmsiModule()
+	if reason, err := checkCompatibility(m.Compatibility(), fixture, "v1.5.42"); err != nil || reason != "" {
+		t.Errorf("fixture should be compatible, got %q, %v", reason, err)
+	}
+
+	// Once patched, the module has nothing left to do
+	src := runModule(t, m, nil)
+	if reason, _ := checkCompatibility(m.Compatibility(), src, "v1.5.42"); reason == "" {
+		t.Error("patched tree should not be compatible")
+	}
+}
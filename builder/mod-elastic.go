@@ -1,22 +1,30 @@
 package main
 
 import (
+	"cloak/pkg/names"
 	"cloak/pkg/subs"
 	"fmt"
+	"log"
 	"path/filepath"
+	"strings"
 )
 
 
 type ElasticModule struct {
 	ignoreList   []string
 	renamePaths  bool
+	symlinks     subs.SymlinkPolicy
+	random       bool
+	wordlist     string
+	builtin      int // leading replacePairs that are not from the extra parameter
 	replacePairs []SearchReplacePair
 }
 
 func NewElasticModule() *ElasticModule {
-	return &ElasticModule{
+	m := &ElasticModule{
 		ignoreList:  []string{".git", ".github", "docs", "vendor"},
 		renamePaths: true,
+		symlinks:    subs.SymlinkSkip,
 		replacePairs: []SearchReplacePair{
 			{search: "IfconfigReq", replace: "Frank"},
 			{search: "ImpersonateReq", replace: "Steve"},
@@ -34,33 +42,112 @@ func NewElasticModule() *ElasticModule {
 			{search: "-NoExit", replace: "-nOExIt"},
 		},
 	}
+	m.builtin = len(m.replacePairs)
+	return m
 }
 
 func (m *ElasticModule) Name() string {
 	return "Elastic"
 }
 
+func (m *ElasticModule) Description() string {
+	return "Renames identifiers matched by Elastic's Sliver detection rules"
+}
+
+func (m *ElasticModule) Params() []Param {
+	return []Param{
+		{Name: "extra", Type: ParamList, Description: "Additional search=replace pairs"},
+		{Name: "ignore", Type: ParamList, Default: ".git,.github,docs,vendor", Description: "Directories to leave untouched"},
+		{Name: "rename-paths", Type: ParamBool, Default: "true", Description: "Also rename matching files and directories"},
+		{Name: "symlinks", Default: "skip", Choices: []string{"skip", "follow", "retarget"}, Description: "Symlinks: skip, follow (rewrite the file they point to) or retarget (rewrite the link path)"},
+		{Name: "random", Type: ParamBool, Default: "false", Description: "Generate the replacement identifiers from the run seed (extra pairs are kept)"},
+		{Name: "wordlist", Description: "File with one word per line to draw random names from, instead of syllables"},
+	}
+}
+
+func (m *ElasticModule) Configure(values ParamValues) error {
+	// Configure may be called more than once, keep only the built-in pairs
+	m.replacePairs = m.replacePairs[:m.builtin]
+	for _, pair := range values.List("extra") {
+		search, replace, found := strings.Cut(pair, "=")
+		if !found || search == "" {
+			return fmt.Errorf("invalid pair %q, expected search=replace", pair)
+		}
+		m.replacePairs = append(m.replacePairs, SearchReplacePair{search: search, replace: replace})
+	}
+	m.ignoreList = values.List("ignore")
+	m.renamePaths = values.Bool("rename-paths")
+	symlinks, err := subs.ParseSymlinkPolicy(values.String("symlinks"))
+	if err != nil {
+		return err
+	}
+	m.symlinks = symlinks
+	m.random = values.Bool("random")
+	m.wordlist = values.String("wordlist")
+	return nil
+}
+
+func (m *ElasticModule) Compatibility() Compatibility {
+	return Compatibility{
+		Versions: ">=1.5.0",
+		Symbols:  []Symbol{{File: "protobuf/sliverpb/sliver.proto", Text: "message IfconfigReq"}},
+	}
+}
+
+func (m *ElasticModule) SearchTerms() []string {
+	terms := make([]string, len(m.replacePairs))
+	for i, pair := range m.replacePairs {
+		terms[i] = pair.search
+	}
+	return terms
+}
+
+// Replacements returns the pairs applied by Run, including generated names
+func (m *ElasticModule) Replacements() []Replacement {
+	return pairReplacements(m.replacePairs)
+}
+
 func (m *ElasticModule) Run(config *Config, verbose bool) error {
-	startPath := filepath.Join(config.RunDir, "sliver")
+	fsys := subs.NewOSFS(filepath.Join(config.RunDir, "sliver"))
+
+	if m.random {
+		g, err := nameGenerator(config, m.Name(), m.wordlist)
+		if err != nil {
+			return err
+		}
+		for i := range m.replacePairs[:m.builtin] {
+			pair := &m.replacePairs[i]
+			if names.IsIdentifier(pair.search) {
+				pair.replace = g.Like(pair.search)
+				if verbose {
+					log.Printf("[Elastic] %s -> %s", pair.search, pair.replace)
+				}
+			}
+		}
+	}
 
 	// Start the recursive search and replace
 	var err error
 	for _, pair := range m.replacePairs {
-
-		err = subs.SearchAndReplace(startPath, pair.search, pair.replace, m.ignoreList, verbose)
+		err = subs.SearchAndReplaceLinks(fsys, pair.search, pair.replace, m.ignoreList, m.symlinks, verbose)
 		if err != nil {
 			return fmt.Errorf("[Elastic] [SearchAndReplace] error during execution: %v", err)
 		}
+	}
 
-		err = subs.SearchAndRenameFiles(startPath, pair.search, pair.replace, m.ignoreList, verbose)
-		if err != nil {
-			return fmt.Errorf("[Elastic] [SearchAndRenameFiles] error during execution: %v", err)
-		}
+	if !m.renamePaths {
+		return nil
+	}
 
-		err = subs.SearchAndRenameDirectories(startPath, pair.search, pair.replace, m.ignoreList, verbose)
-		if err != nil {
-			return fmt.Errorf("[Elastic] [SearchAndRenameDirectories] error during execution: %v", err)
-		}
+	// Plan the renames for all pairs at once, so collisions are reported
+	// before anything is moved
+	rules := make([]subs.Rule, len(m.replacePairs))
+	for i, pair := range m.replacePairs {
+		rules[i] = subs.Rule{Search: pair.search, Replace: pair.replace}
+	}
+	err = subs.RenamePaths(fsys, rules, m.ignoreList, verbose)
+	if err != nil {
+		return fmt.Errorf("[Elastic] [RenamePaths] error during execution: %v", err)
 	}
 
 	return nil
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+func TestElasticModule(t *testing.T) {
+	src := runModule(t, NewElasticModule(), nil)
+	moduletest.CompareGolden(t, src, filepath.Join("testdata", "golden", "Elastic"))
+
+	for _, identifier := range []string{"IfconfigReq", "ScreenshotReq", "GetPrivInfo", "NetstatReq", "httpSessionInit", "-NoExit"} {
+		moduletest.AssertCount(t, src, identifier, 0)
+	}
+	moduletest.AssertCount(t, src, "message Frank", 1)
+}
+
+func TestElasticModuleExtraPairs(t *testing.T) {
+	src := runModule(t, NewElasticModule(), ParamValues{"extra": "BeaconMain=Orbit"})
+	moduletest.AssertCount(t, src, "BeaconMain", 0)
+	moduletest.AssertCount(t, src, "Orbit", 2)
+
+	values, err := resolveParams(NewElasticModule(), ParamValues{"extra": "no-separator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewElasticModule().Configure(values); err == nil {
+		t.Error("pair without = was accepted")
+	}
+
+	// Configuring again, as validation before a run does, keeps one copy
+	m := NewElasticModule()
+	values, err = resolveParams(m, ParamValues{"extra": "BeaconMain=Orbit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.Configure(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(m.SearchTerms()); got != m.builtin+1 {
+		t.Errorf("%d search terms after configuring twice, want %d", got, m.builtin+1)
+	}
+}
+
+func TestElasticModuleCompatibility(t *testing.T) {
+	c := NewElasticModule().Compatibility()
+	for version, compatible := range map[string]bool{"v1.5.42": true, "v1.6.0": true, "v1.4.22": false, "": false} {
+		reason, err := checkCompatibility(c, fixture, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (reason == "") != compatible {
+			t.Errorf("version %q: compatible = %v, want %v (%s)", version, reason == "", compatible, reason)
+		}
+	}
+}
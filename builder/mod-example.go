@@ -6,22 +6,52 @@ import (
 
 type ExampleModule struct {
 	// Add any module-specific configuration here
+	message string
 }
 
 func NewExampleModule() *ExampleModule {
-	return &ExampleModule{}
+	return &ExampleModule{
+		message: "hey from example module \\o/",
+	}
 }
 
 func (m *ExampleModule) Name() string {
 	return "example"
 }
 
+func (m *ExampleModule) Description() string {
+	return "Example module that only logs a message"
+}
+
+// Params declares the parameters users can set with -set example.<name>=...
+func (m *ExampleModule) Params() []Param {
+	return []Param{
+		{Name: "message", Default: "hey from example module \\o/", Description: "Message to log in verbose mode"},
+	}
+}
+
+// Configure receives the validated parameter values before cloning
+func (m *ExampleModule) Configure(values ParamValues) error {
+	m.message = values.String("message")
+	return nil
+}
+
 func (m *ExampleModule) Run(config *Config, verbose bool) error {
 	if verbose {
-		log.Println("hey from example module \\o/")
+		log.Println(m.message)
 	}
 
 	// do stuff
 
 	return nil
 }
+
+// PostBuild is one of the optional hooks in hooks.go, called after make
+func (m *ExampleModule) PostBuild(config *Config, artifacts []Artifact, verbose bool) ([]Artifact, error) {
+	if verbose {
+		for _, artifact := range artifacts {
+			log.Printf("example module saw %s (%d bytes)", artifact.Name, artifact.Size)
+		}
+	}
+	return artifacts, nil
+}
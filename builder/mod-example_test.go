@@ -0,0 +1,12 @@
+package main
+
+import (
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+func TestExampleModuleLeavesTreeUnchanged(t *testing.T) {
+	src := runModule(t, NewExampleModule(), ParamValues{"message": "testing"})
+	moduletest.AssertSameTree(t, src, fixture)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"cloak/pkg/names"
+	"cloak/pkg/subs"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Overwrite policies for injected files whose destination already exists
+const (
+	OverwriteNever  = "never"  // fail the module
+	OverwriteSkip   = "skip"   // keep the existing file
+	OverwriteAlways = "always" // replace the existing file
+)
+
+// InjectFile maps a template source to a destination in the source tree.
+// A source directory injects every file under it, with a trailing .tmpl
+// dropped from rendered file names.
+type InjectFile struct {
+	Src       string `yaml:"src"`                 // relative to the definition file
+	Dest      string `yaml:"dest"`                // relative to the source tree, rendered as a template
+	Overwrite string `yaml:"overwrite,omitempty"` // never, skip or always, never if empty
+	Mode      string `yaml:"mode,omitempty"`      // octal permission bits, the source's if empty
+	Raw       bool   `yaml:"raw,omitempty"`       // copy without rendering, implied for binary files
+
+	mode fs.FileMode
+}
+
+// parse validates the entry and resolves its source against dir
+func (f *InjectFile) parse(dir string) error {
+	if f.Src == "" || f.Dest == "" {
+		return errors.New("src and dest are required")
+	}
+	if !filepath.IsAbs(f.Src) {
+		f.Src = filepath.Join(dir, f.Src)
+	}
+	if _, err := os.Stat(f.Src); err != nil {
+		return fmt.Errorf("invalid src: %w", err)
+	}
+
+	switch f.Overwrite {
+	case "":
+		f.Overwrite = OverwriteNever
+	case OverwriteNever, OverwriteSkip, OverwriteAlways:
+	default:
+		return fmt.Errorf("%s: invalid overwrite %q, expected never, skip or always", f.Dest, f.Overwrite)
+	}
+
+	if f.Mode != "" {
+		mode, err := strconv.ParseUint(f.Mode, 8, 32)
+		if err != nil || mode&^0777 != 0 {
+			return fmt.Errorf("%s: invalid mode %q, expected octal permission bits like 0644", f.Dest, f.Mode)
+		}
+		f.mode = fs.FileMode(mode)
+	}
+	return nil
+}
+
+// loadInjectFiles reads and validates an inject definition file
+func loadInjectFiles(path string) ([]InjectFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inject definition: %w", err)
+	}
+	var files []InjectFile
+	if err := yaml.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse inject definition %s: %w", path, err)
+	}
+	for i := range files {
+		if err := files[i].parse(filepath.Dir(path)); err != nil {
+			return nil, fmt.Errorf("file %d in %s: %w", i+1, path, err)
+		}
+	}
+	return files, nil
+}
+
+// InjectData is what inject templates are rendered with
+type InjectData struct {
+	Target     string            // Tag being built
+	GitRef     string            // Git ref being built
+	RunID      string            // Name of the run directory
+	Seed       int64             // Seed of the run
+	Engagement string            // Engagement ID, empty if none
+	Operator   string            // Operator, empty if none
+	Vars       map[string]string // The module's vars parameter
+}
+
+type InjectModule struct {
+	files    []InjectFile
+	vars     map[string]string
+	wordlist string
+}
+
+func NewInjectModule() *InjectModule {
+	return &InjectModule{}
+}
+
+func (m *InjectModule) Name() string {
+	return "inject"
+}
+
+func (m *InjectModule) Description() string {
+	return "Adds files to the source tree, rendered from templates with run variables"
+}
+
+func (m *InjectModule) Params() []Param {
+	return []Param{
+		{Name: "files", Description: "YAML file mapping template sources to destinations, see the README"},
+		{Name: "vars", Type: ParamList, Description: "key=value pairs available to templates as .Vars"},
+		{Name: "wordlist", Description: "File with one word per line for the name template function, instead of syllables"},
+	}
+}
+
+func (m *InjectModule) Configure(values ParamValues) error {
+	m.files = nil
+	m.vars = make(map[string]string)
+	m.wordlist = values.String("wordlist")
+	for _, pair := range values.List("vars") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid var %q, expected key=value", pair)
+		}
+		m.vars[key] = value
+	}
+	if values.String("files") == "" {
+		return nil
+	}
+	files, err := loadInjectFiles(values.String("files"))
+	if err != nil {
+		return err
+	}
+	m.files = files
+	return nil
+}
+
+func (m *InjectModule) Run(config *Config, verbose bool) error {
+	if len(m.files) == 0 {
+		log.Println("[inject] no files given, set inject.files")
+		return nil
+	}
+	fsys := subs.NewOSFS(filepath.Join(config.RunDir, "sliver"))
+	data := InjectData{
+		Target:     config.Target.Tag,
+		GitRef:     config.Target.GitRef,
+		RunID:      filepath.Base(config.RunDir),
+		Seed:       config.Seed,
+		Engagement: config.Engagement,
+		Operator:   config.Operator,
+		Vars:       m.vars,
+	}
+	funcs := m.funcs(config)
+
+	for _, file := range m.files {
+		dest, err := renderTemplate(file.Dest, []byte(file.Dest), data, funcs)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(file.Src)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := m.inject(fsys, file, file.Src, string(dest), data, funcs, verbose); err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.WalkDir(file.Src, func(src string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(file.Src, src)
+			if err != nil {
+				return err
+			}
+			if !file.Raw {
+				rel = strings.TrimSuffix(rel, ".tmpl")
+			}
+			return m.inject(fsys, file, src, path.Join(string(dest), filepath.ToSlash(rel)), data, funcs, verbose)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// funcs returns the template functions. name returns a generated name in
+// the style of its argument, the same one for the same argument throughout
+// the run; rename applies the replacements of the modules run before.
+func (m *InjectModule) funcs(config *Config) template.FuncMap {
+	var g *names.Generator
+	generated := make(map[string]string)
+	return template.FuncMap{
+		"name": func(key string) (string, error) {
+			if name, ok := generated[key]; ok {
+				return name, nil
+			}
+			// Reserving the tree is slow, so only done when names are used
+			if g == nil {
+				var err error
+				if g, err = nameGenerator(config, m.Name(), m.wordlist); err != nil {
+					return "", err
+				}
+			}
+			generated[key] = g.Like(key)
+			return generated[key], nil
+		},
+		"rename": func(s string) string {
+			for _, r := range config.Renames {
+				s = strings.ReplaceAll(s, r.Search, r.Replace)
+			}
+			return s
+		},
+	}
+}
+
+// inject writes one source file to dest according to the entry's policy
+func (m *InjectModule) inject(fsys subs.FS, file InjectFile, src, dest string, data InjectData, funcs template.FuncMap, verbose bool) error {
+	dest = path.Clean(dest)
+	if path.IsAbs(dest) || !fs.ValidPath(dest) || dest == "." || strings.SplitN(dest, "/", 2)[0] == ".git" {
+		return fmt.Errorf("destination %q must be a path inside the source tree", dest)
+	}
+
+	if info, err := fsys.Lstat(dest); err == nil {
+		switch {
+		case info.IsDir():
+			return fmt.Errorf("destination %s is a directory", dest)
+		case file.Overwrite == OverwriteSkip:
+			if verbose {
+				log.Printf("[inject] %s exists, skipping", dest)
+			}
+			return nil
+		case file.Overwrite == OverwriteNever:
+			return fmt.Errorf("destination %s exists, set overwrite to replace or skip it", dest)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+	if !file.Raw && bytes.IndexByte(content, 0) < 0 {
+		if content, err = renderTemplate(src, content, data, funcs); err != nil {
+			return err
+		}
+	}
+	mode := file.mode
+	if mode == 0 {
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		mode = info.Mode().Perm()
+	}
+
+	if err := fsys.MkdirAll(path.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dest, err)
+	}
+	if err := fsys.WriteFile(dest, content, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	if verbose {
+		log.Printf("[inject] %s -> %s (%v)", src, dest, mode)
+	}
+	return nil
+}
+
+// renderTemplate executes text as a template named name. Unknown vars are errors.
+func renderTemplate(name string, text []byte, data InjectData, funcs template.FuncMap) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(name)).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
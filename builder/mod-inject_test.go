@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+// injectConfig is the run configuration the inject tests render with
+func injectConfig(runDir string) *Config {
+	return &Config{
+		RunDir:     runDir,
+		Target:     BuildTarget{Tag: "v1.5.42", GitRef: "v1.5.42"},
+		Seed:       42,
+		Engagement: "ENG-7",
+		Renames: []Replacement{
+			{Search: "bishopfox", Replace: "knightbruce"},
+			{Search: "sliver", Replace: "gunner"},
+		},
+	}
+}
+
+// runInject runs the inject module with a definition against the mini
+// fixture and returns the module's error and the resulting source tree
+func runInject(t *testing.T, definition string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "template.txt"), []byte("{{ .Target }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "inject.yaml")
+	if err := os.WriteFile(path, []byte(definition), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewInjectModule()
+	if err := m.Configure(ParamValues{"files": path}); err != nil {
+		t.Fatal(err)
+	}
+	runDir := moduletest.Setup(t, fixture)
+	err := m.Run(injectConfig(runDir), false)
+	return filepath.Join(runDir, moduletest.SourceDir), err
+}
+
+func TestInjectModule(t *testing.T) {
+	m := NewInjectModule()
+	err := m.Configure(ParamValues{
+		"files": filepath.Join("testdata", "inject", "inject.yaml"),
+		"vars":  "flavor=blue",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := moduletest.Run(t, fixture, func(runDir string) error {
+		return m.Run(injectConfig(runDir), false)
+	})
+	moduletest.CompareGolden(t, src, filepath.Join("testdata", "golden", "inject"))
+	moduletest.AssertMode(t, src, "client/assets/motd.txt", 0600)
+	moduletest.AssertMode(t, src, "scripts/build-blue.sh", 0755)
+}
+
+func TestInjectOverwrite(t *testing.T) {
+	if _, err := runInject(t, "- src: template.txt\n  dest: docs/sliver.md\n"); err == nil {
+		t.Error("injecting over an existing file succeeded without overwrite")
+	}
+
+	src, err := runInject(t, "- src: template.txt\n  dest: docs/sliver.md\n  overwrite: skip\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moduletest.AssertCount(t, src, "v1.5.42", 0)
+
+	src, err = runInject(t, "- src: template.txt\n  dest: docs/sliver.md\n  overwrite: always\n  mode: \"0640\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moduletest.AssertCount(t, src, "v1.5.42", 1)
+	moduletest.AssertMode(t, src, "docs/sliver.md", 0640)
+}
+
+func TestInjectDestination(t *testing.T) {
+	for _, dest := range []string{"../escape.txt", "/etc/passwd", ".git/config", "{{ .Vars.missing }}", "docs"} {
+		if _, err := runInject(t, "- src: template.txt\n  dest: \""+dest+"\"\n"); err == nil {
+			t.Errorf("injecting to %s succeeded", dest)
+		}
+	}
+}
+
+func TestInjectValidation(t *testing.T) {
+	for _, definition := range []string{
+		"- src: template.txt\n",
+		"- dest: a.txt\n",
+		"- src: missing.txt\n  dest: a.txt\n",
+		"- src: template.txt\n  dest: a.txt\n  overwrite: sometimes\n",
+		"- src: template.txt\n  dest: a.txt\n  mode: rwx\n",
+		"- src: template.txt\n  dest: a.txt\n  mode: \"01777\"\n",
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "template.txt"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "inject.yaml")
+		if err := os.WriteFile(path, []byte(definition), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadInjectFiles(path); err == nil {
+			t.Errorf("invalid definition accepted: %s", strings.TrimSpace(definition))
+		}
+	}
+
+	if err := NewInjectModule().Configure(ParamValues{"vars": "novalue"}); err == nil {
+		t.Error("var without a value accepted")
+	}
+}
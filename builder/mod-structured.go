@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cloak/pkg/structured"
+	"cloak/pkg/subs"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StructuredEdit is one operation of an edits file. Exactly one of Set,
+// Delete and Append holds the path the operation applies to.
+type StructuredEdit struct {
+	File     string    `yaml:"file"`             // relative to the source tree
+	Format   string    `yaml:"format,omitempty"` // json or yaml, from the extension if empty
+	Set      string    `yaml:"set,omitempty"`
+	Delete   string    `yaml:"delete,omitempty"`
+	Append   string    `yaml:"append,omitempty"`
+	Value    yaml.Node `yaml:"value,omitempty"`
+	Optional bool      `yaml:"optional,omitempty"` // a missing file or path is not an error
+
+	op     string
+	path   structured.Path
+	format structured.Format
+}
+
+// parse validates the edit and fills in its operation, path and format
+func (e *StructuredEdit) parse() error {
+	if e.File == "" || path.IsAbs(e.File) || !fs.ValidPath(e.File) {
+		return fmt.Errorf("file %q must be a path inside the source tree", e.File)
+	}
+
+	var ops []string
+	for _, op := range []struct{ name, path string }{{"set", e.Set}, {"delete", e.Delete}, {"append", e.Append}} {
+		if op.path != "" {
+			ops = append(ops, op.name)
+			e.op = op.name
+			p, err := structured.ParsePath(op.path)
+			if err != nil {
+				return err
+			}
+			e.path = p
+		}
+	}
+	if len(ops) != 1 {
+		return fmt.Errorf("%s: give exactly one of set, delete and append", e.File)
+	}
+	if hasValue := e.Value.Kind != 0; hasValue != (e.op != "delete") {
+		if hasValue {
+			return fmt.Errorf("%s: delete takes no value", e.File)
+		}
+		return fmt.Errorf("%s: %s %s needs a value", e.File, e.op, e.path)
+	}
+
+	var err error
+	if e.Format != "" {
+		e.format, err = structured.ParseFormat(e.Format)
+	} else {
+		e.format, err = structured.FormatOf(e.File)
+	}
+	return err
+}
+
+// apply runs the edit on a document and returns the number of nodes changed
+func (e *StructuredEdit) apply(doc *structured.Document) (int, error) {
+	switch e.op {
+	case "set":
+		return doc.Set(e.path, &e.Value, e.Optional)
+	case "delete":
+		return doc.Delete(e.path, e.Optional)
+	default:
+		return doc.Append(e.path, &e.Value, e.Optional)
+	}
+}
+
+// loadStructuredEdits reads and validates an edits file
+func loadStructuredEdits(path string) ([]StructuredEdit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read edits: %w", err)
+	}
+	var edits []StructuredEdit
+	if err := yaml.Unmarshal(data, &edits); err != nil {
+		return nil, fmt.Errorf("failed to parse edits %s: %w", path, err)
+	}
+	for i := range edits {
+		if err := edits[i].parse(); err != nil {
+			return nil, fmt.Errorf("edit %d in %s: %w", i+1, path, err)
+		}
+	}
+	return edits, nil
+}
+
+type StructuredEditModule struct {
+	edits []StructuredEdit
+}
+
+func NewStructuredEditModule() *StructuredEditModule {
+	return &StructuredEditModule{}
+}
+
+func (m *StructuredEditModule) Name() string {
+	return "structured"
+}
+
+func (m *StructuredEditModule) Description() string {
+	return "Sets, deletes and appends values in JSON and YAML files by path"
+}
+
+func (m *StructuredEditModule) Params() []Param {
+	return []Param{
+		{Name: "edits", Description: "YAML file listing the edits, see the README"},
+	}
+}
+
+func (m *StructuredEditModule) Configure(values ParamValues) error {
+	m.edits = nil
+	if values.String("edits") == "" {
+		return nil
+	}
+	edits, err := loadStructuredEdits(values.String("edits"))
+	if err != nil {
+		return err
+	}
+	m.edits = edits
+	return nil
+}
+
+func (m *StructuredEditModule) Run(config *Config, verbose bool) error {
+	if len(m.edits) == 0 {
+		log.Println("[structured] no edits given, set structured.edits")
+		return nil
+	}
+	fsys := subs.NewOSFS(filepath.Join(config.RunDir, "sliver"))
+
+	// Every file is parsed once and written once, after all its edits
+	docs := make(map[string]*structured.Document)
+	var order []string
+	for i := range m.edits {
+		edit := &m.edits[i]
+		doc, loaded := docs[edit.File]
+		if !loaded {
+			data, err := fsys.ReadFile(edit.File)
+			if errors.Is(err, fs.ErrNotExist) && edit.Optional {
+				if verbose {
+					log.Printf("[structured] %s not found, skipping optional %s %s", edit.File, edit.op, edit.path)
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", edit.File, err)
+			}
+			doc, err = structured.Parse(data, edit.format)
+			if err != nil {
+				return fmt.Errorf("%s: %w", edit.File, err)
+			}
+			docs[edit.File] = doc
+			order = append(order, edit.File)
+		}
+		if doc.Format() != edit.format {
+			return fmt.Errorf("%s: edited as both %s and %s", edit.File, doc.Format(), edit.format)
+		}
+
+		n, err := edit.apply(doc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", edit.File, err)
+		}
+		if verbose {
+			log.Printf("[structured] %s: %s %s (%d)", edit.File, edit.op, edit.path, n)
+		}
+	}
+
+	for _, file := range order {
+		data, err := docs[file].Bytes()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		info, err := fsys.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := fsys.WriteFile(file, data, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloak/pkg/moduletest"
+	"cloak/pkg/structured"
+)
+
+// configsFixture is a tree with JSON and YAML configs
+var configsFixture = filepath.Join("testdata", "fixtures", "configs")
+
+// runStructured runs the structured module with edits against the configs
+// fixture and returns the module's error and the resulting source tree
+func runStructured(t *testing.T, edits string) (string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "edits.yaml")
+	if err := os.WriteFile(path, []byte(edits), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewStructuredEditModule()
+	if err := m.Configure(ParamValues{"edits": path}); err != nil {
+		t.Fatal(err)
+	}
+	runDir := moduletest.Setup(t, configsFixture)
+	err := m.Run(&Config{RunDir: runDir}, false)
+	return filepath.Join(runDir, moduletest.SourceDir), err
+}
+
+func TestStructuredEditModule(t *testing.T) {
+	m := NewStructuredEditModule()
+	if err := m.Configure(ParamValues{"edits": filepath.Join("testdata", "structured", "edits.yaml")}); err != nil {
+		t.Fatal(err)
+	}
+	src := moduletest.Run(t, configsFixture, func(runDir string) error {
+		return m.Run(&Config{RunDir: runDir}, false)
+	})
+	moduletest.CompareGolden(t, src, filepath.Join("testdata", "golden", "structured"))
+}
+
+func TestStructuredEditModuleMissingPath(t *testing.T) {
+	_, err := runStructured(t, "- file: server/configs/server.yaml\n  set: $.daemon.tls\n  value: true\n")
+	if !errors.Is(err, structured.ErrNotFound) {
+		t.Errorf("set on a missing path: got %v, want ErrNotFound", err)
+	}
+
+	// Nothing is written when an edit fails
+	src, err := runStructured(t, "- file: server/configs/server.yaml\n  set: $.daemon.port\n  value: 1\n"+
+		"- file: server/configs/server.yaml\n  delete: $.nope\n")
+	if err == nil {
+		t.Fatal("delete of a missing path succeeded")
+	}
+	moduletest.AssertCount(t, src, "port: 31337", 1)
+
+	if _, err := runStructured(t, "- file: server/configs/missing.json\n  delete: $.a\n"); err == nil {
+		t.Error("edit of a missing file succeeded")
+	}
+}
+
+func TestStructuredEditValidation(t *testing.T) {
+	for _, edit := range []string{
+		"- file: a.json\n",
+		"- file: a.json\n  set: $.a\n",
+		"- file: a.json\n  delete: $.a\n  value: 1\n",
+		"- file: a.json\n  set: $.a\n  delete: $.b\n  value: 1\n",
+		"- file: ../a.json\n  delete: $.a\n",
+		"- file: a.toml\n  delete: $.a\n",
+		"- file: a.json\n  delete: $.a[x]\n",
+	} {
+		path := filepath.Join(t.TempDir(), "edits.yaml")
+		if err := os.WriteFile(path, []byte(edit), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadStructuredEdits(path); err == nil {
+			t.Errorf("invalid edit accepted: %s", strings.TrimSpace(edit))
+		}
+	}
+}
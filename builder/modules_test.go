@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+// fixture is the small Sliver-like tree the module tests run against
+var fixture = filepath.Join("testdata", "fixtures", "mini")
+
+// runModule configures m with params the way the builder does and runs it
+// against the fixture tree, returning the resulting source tree
+func runModule(t *testing.T, m Module, params ParamValues) string {
+	t.Helper()
+	values, err := resolveParams(m, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := m.(Configurable); ok {
+		if err := c.Configure(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return moduletest.Run(t, fixture, func(runDir string) error {
+		return m.Run(&Config{RunDir: runDir}, false)
+	})
+}
+
+func TestRegisteredModules(t *testing.T) {
+	builder := NewBuilder(nil, false)
+	registerModules(builder)
+
+	for _, m := range builder.Modules() {
+		if moduleDescription(m) == "" {
+			t.Errorf("module %s has no description", m.Name())
+		}
+		if _, err := resolveParams(m, nil); err != nil {
+			t.Errorf("module %s: defaults do not validate: %v", m.Name(), err)
+		}
+	}
+}
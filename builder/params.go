@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParamType is the type of a module parameter value
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	ParamInt    ParamType = "int"
+	ParamBool   ParamType = "bool"
+	ParamList   ParamType = "list" // comma-separated strings
+)
+
+// Param describes a user-facing parameter of a module
+type Param struct {
+	Name        string
+	Type        ParamType // defaults to ParamString
+	Default     string
+	Required    bool
+	Choices     []string // optional set of allowed values
+	Description string
+}
+
+// Parameterized is implemented by modules that accept parameters
+type Parameterized interface {
+	Params() []Param
+}
+
+// Configurable is implemented by parameterized modules to receive their
+// resolved parameter values. Configure is called before the source is cloned,
+// so errors returned for invalid values fail the run early.
+type Configurable interface {
+	Configure(values ParamValues) error
+}
+
+// ParamValues holds parameter values by name. Values are kept as strings and
+// converted by the typed accessors after validation against the schema.
+type ParamValues map[string]string
+
+// String returns a parameter value
+func (v ParamValues) String(name string) string {
+	return v[name]
+}
+
+// Int returns an integer parameter value, or 0 if it is not set
+func (v ParamValues) Int(name string) int {
+	n, _ := strconv.Atoi(v[name])
+	return n
+}
+
+// Bool returns a boolean parameter value, or false if it is not set
+func (v ParamValues) Bool(name string) bool {
+	b, _ := strconv.ParseBool(v[name])
+	return b
+}
+
+// List returns the elements of a list parameter value
+func (v ParamValues) List(name string) []string {
+	return splitList(v[name])
+}
+
+// UnmarshalYAML accepts scalars and, for list parameters, sequences of
+// scalars as values
+func (v *ParamValues) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: parameters must be a mapping", node.Line)
+	}
+	values := make(ParamValues)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch value.Kind {
+		case yaml.ScalarNode:
+			values[key.Value] = value.Value
+		case yaml.SequenceNode:
+			var items []string
+			if err := value.Decode(&items); err != nil {
+				return err
+			}
+			values[key.Value] = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("line %d: parameter %s must be a scalar or a list", value.Line, key.Value)
+		}
+	}
+	*v = values
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty elements
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// moduleParams returns the parameters a module declares, if any
+func moduleParams(m Module) []Param {
+	if p, ok := m.(Parameterized); ok {
+		return p.Params()
+	}
+	return nil
+}
+
+// moduleDescription returns the description of a module, if it has one
+func moduleDescription(m Module) string {
+	if d, ok := m.(Describer); ok {
+		return d.Description()
+	}
+	return ""
+}
+
+// paramType returns the declared type of a parameter
+func (p Param) paramType() ParamType {
+	if p.Type == "" {
+		return ParamString
+	}
+	return p.Type
+}
+
+// check validates a value against the parameter's type and choices
+func (p Param) check(value string) error {
+	var err error
+	switch p.paramType() {
+	case ParamString, ParamList:
+	case ParamInt:
+		_, err = strconv.Atoi(value)
+	case ParamBool:
+		_, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown type %s", p.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, p.paramType())
+	}
+
+	if len(p.Choices) > 0 {
+		for _, choice := range p.Choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(p.Choices, ", "))
+	}
+	return nil
+}
+
+// checkParams validates values against a module's schema
+func checkParams(m Module, values ParamValues) error {
+	schema := make(map[string]Param)
+	for _, p := range moduleParams(m) {
+		schema[p.Name] = p
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		p, declared := schema[key]
+		if !declared {
+			return fmt.Errorf("module %s has no parameter %s", m.Name(), key)
+		}
+		if err := p.check(values[key]); err != nil {
+			return fmt.Errorf("invalid value for %s.%s: %w", m.Name(), key, err)
+		}
+	}
+	return nil
+}
+
+// resolveParams validates values against a module's schema and returns
+// them with defaults filled in
+func resolveParams(m Module, values ParamValues) (ParamValues, error) {
+	if err := checkParams(m, values); err != nil {
+		return nil, err
+	}
+
+	resolved := make(ParamValues)
+	for _, p := range moduleParams(m) {
+		value, set := values[p.Name]
+		if !set {
+			if p.Required {
+				return nil, fmt.Errorf("module %s requires parameter %s", m.Name(), p.Name)
+			}
+			value = p.Default
+		}
+		resolved[p.Name] = value
+	}
+	return resolved, nil
+}
+
+// parseSetting parses a -set argument of the form module.key=value
+func parseSetting(s string) (module, key, value string, err error) {
+	name, value, found := strings.Cut(s, "=")
+	module, key, dotted := strings.Cut(name, ".")
+	if !found || !dotted || module == "" || key == "" {
+		return "", "", "", fmt.Errorf("invalid setting %q, expected module.key=value", s)
+	}
+	return module, key, value, nil
+}
+
+// validateModules checks that every selected module is registered and that
+// the configured parameters belong to a registered module that declares
+// them. The resolved values are passed to the selected modules and recorded
+// in the run metadata.
+func (b *Builder) validateModules(moduleNames []string) error {
+	for _, name := range moduleNames {
+		if _, exists := b.modules[name]; !exists {
+			return fmt.Errorf("module %s not found", name)
+		}
+	}
+	for name, values := range b.config.ModuleParams {
+		module, exists := b.modules[name]
+		if !exists {
+			return fmt.Errorf("parameters given for unknown module %s", name)
+		}
+		if err := checkParams(module, values); err != nil {
+			return err
+		}
+	}
+
+	resolved := make(map[string]ParamValues)
+	for _, name := range moduleNames {
+		module := b.modules[name]
+		values, err := resolveParams(module, b.config.ModuleParams[name])
+		if err != nil {
+			return err
+		}
+		if c, ok := module.(Configurable); ok {
+			if err := c.Configure(values); err != nil {
+				return fmt.Errorf("module %s: %w", name, err)
+			}
+		}
+		if len(values) > 0 {
+			resolved[name] = values
+		}
+	}
+	b.meta.Params = resolved
+	return nil
+}
@@ -0,0 +1,306 @@
+// Package audit implements an append-only, hash-chained log.
+//
+// The log is a JSON lines file. Every entry carries its sequence number, the
+// hash of the entry before it and its own hash, a SHA-256 over the entry
+// without the hash field. Changing, removing or reordering entries breaks
+// the chain, which Verify reports. Truncation at the end is caught by a head
+// file next to the log that holds the sequence number and hash of the last
+// entry.
+//
+//	l := audit.Open("audit.jsonl")
+//	if _, err := l.Append("run.start", record); err != nil {
+//		return err
+//	}
+//	report, err := audit.Verify("audit.jsonl")
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Genesis is the previous hash of the first entry
+var Genesis = strings.Repeat("0", 64)
+
+// HeadSuffix is appended to the log path for the head file
+const HeadSuffix = ".head"
+
+// lockTimeout bounds how long Append waits for another process
+const lockTimeout = 10 * time.Second
+
+// Entry is one record of the log
+type Entry struct {
+	Seq   int64           `json:"seq"`
+	Time  time.Time       `json:"time"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Prev  string          `json:"prev"`
+	Hash  string          `json:"hash,omitempty"`
+}
+
+// head is the content of the head file
+type head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Sum returns the hash of an entry, computed over its JSON encoding
+// without the hash field
+func (e Entry) Sum() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log is an audit log file
+type Log struct {
+	path string
+}
+
+// Open returns the log at path. The file is created by the first Append.
+func Open(path string) *Log {
+	return &Log{path: path}
+}
+
+// Path returns the path of the log file
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append adds an event with data, encoded as JSON, to the log. It refuses
+// to extend a log whose last entry does not parse or does not match the
+// head file.
+func (l *Log) Append(event string, data interface{}) (*Entry, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit data: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	unlock, err := lock(l.path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	last, err := lastEntry(l.path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHead(l.path + HeadSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := Entry{Seq: 1, Time: time.Now().UTC(), Event: event, Data: raw, Prev: Genesis}
+	switch {
+	case last != nil && (h == nil || h.Seq != last.Seq || h.Hash != last.Hash):
+		return nil, fmt.Errorf("audit log %s does not match its head file", l.path)
+	case last == nil && h != nil:
+		return nil, fmt.Errorf("audit log %s is missing or empty, but its head file records %d entries", l.path, h.Seq)
+	case last != nil:
+		entry.Seq = last.Seq + 1
+		entry.Prev = last.Hash
+	}
+	if entry.Hash, err = entry.Sum(); err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := writeHead(l.path+HeadSuffix, head{Seq: entry.Seq, Hash: entry.Hash}); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// lock creates a lock file, waiting for another holder to remove it
+func lock(path string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock audit log: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("audit log is locked by %s, remove it if no cloak process is running", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// lastEntry returns the last entry of the log, or nil if it has none
+func lastEntry(path string) (*Entry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	line := data[bytes.LastIndexByte(data, '\n')+1:]
+	var entry Entry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, fmt.Errorf("last entry of audit log %s is corrupt: %w", path, err)
+	}
+	return &entry, nil
+}
+
+func readHead(path string) (*head, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read audit head: %w", err)
+	}
+	var h head
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("audit head %s is corrupt: %w", path, err)
+	}
+	return &h, nil
+}
+
+// writeHead replaces the head file through a rename
+func writeHead(path string, h head) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	return nil
+}
+
+// Problem is an inconsistency found by Verify
+type Problem struct {
+	Line    int    `json:"line"` // 0 for the head file
+	Seq     int64  `json:"seq,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// Report is the result of Verify
+type Report struct {
+	Entries  []Entry   `json:"entries"`
+	Problems []Problem `json:"problems"`
+}
+
+// OK reports whether the log verified without problems
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify checks every entry of the log at path: that it parses, that its
+// hash matches its content, that sequence numbers have no gaps and that it
+// links to the entry before it. The last entry must match the head file.
+// Errors are only returned when the log cannot be read at all.
+func Verify(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	report := &Report{Entries: []Entry{}, Problems: []Problem{}}
+	problem := func(line int, seq int64, format string, args ...interface{}) {
+		report.Problems = append(report.Problems, Problem{Line: line, Seq: seq, Message: fmt.Sprintf(format, args...)})
+	}
+
+	prev := Entry{Hash: Genesis}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			problem(line, 0, "empty line")
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			problem(line, 0, "entry does not parse: %v", err)
+			continue
+		}
+		sum, err := entry.Sum()
+		if err != nil {
+			problem(line, entry.Seq, "entry cannot be hashed: %v", err)
+		} else if sum != entry.Hash {
+			problem(line, entry.Seq, "entry was modified (hash %s, content hashes to %s)", short(entry.Hash), short(sum))
+		}
+		if entry.Seq != prev.Seq+1 {
+			problem(line, entry.Seq, "sequence jumps from %d to %d", prev.Seq, entry.Seq)
+		}
+		if entry.Prev != prev.Hash {
+			problem(line, entry.Seq, "previous hash %s does not match entry %d (%s)", short(entry.Prev), prev.Seq, short(prev.Hash))
+		}
+		report.Entries = append(report.Entries, entry)
+		prev = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	h, err := readHead(path + HeadSuffix)
+	switch {
+	case err != nil:
+		problem(0, 0, "%v", err)
+	case h == nil && len(report.Entries) > 0:
+		problem(0, 0, "head file %s is missing", path+HeadSuffix)
+	case h != nil && (h.Seq != prev.Seq || h.Hash != prev.Hash):
+		problem(0, 0, "log ends at entry %d (%s), but the head file records entry %d (%s): entries were removed or added",
+			prev.Seq, short(prev.Hash), h.Seq, short(h.Hash))
+	}
+	return report, nil
+}
+
+// short abbreviates a hash for messages
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newLog returns a log with n entries
+func newLog(t *testing.T, n int) *Log {
+	t.Helper()
+	l := Open(filepath.Join(t.TempDir(), "audit", "audit.jsonl"))
+	for i := 0; i < n; i++ {
+		if _, err := l.Append("run.finish", map[string]interface{}{"run": i, "html": "<b>&</b>"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l
+}
+
+// lines returns the lines of the log file
+func lines(t *testing.T, l *Log) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(l.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.SplitAfter(bytes.TrimRight(data, "\n"), []byte("\n"))
+}
+
+func writeLines(t *testing.T, l *Log, lines [][]byte) {
+	t.Helper()
+	data := bytes.Join(lines, nil)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	if err := os.WriteFile(l.Path(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func verify(t *testing.T, l *Log) []string {
+	t.Helper()
+	report, err := Verify(l.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problems []string
+	for _, p := range report.Problems {
+		problems = append(problems, p.String())
+	}
+	return problems
+}
+
+func TestAppendVerify(t *testing.T) {
+	l := newLog(t, 3)
+	report, err := Verify(l.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("problems in an untouched log: %v", report.Problems)
+	}
+	if len(report.Entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(report.Entries))
+	}
+	for i, entry := range report.Entries {
+		if entry.Seq != int64(i+1) {
+			t.Errorf("entry %d has seq %d", i, entry.Seq)
+		}
+	}
+	if report.Entries[0].Prev != Genesis || report.Entries[2].Prev != report.Entries[1].Hash {
+		t.Errorf("entries are not chained: %+v", report.Entries)
+	}
+}
+
+func TestVerifyTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		want   string
+	}{
+		{"modified", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"run":1`), []byte(`"run":7`), 1)
+			return lines
+		}, "line 2: entry was modified"},
+		{"removed", func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, "line 2: sequence jumps from 1 to 3"},
+		{"reordered", func(lines [][]byte) [][]byte {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, "line 1: sequence jumps from 0 to 2"},
+		{"truncated", func(lines [][]byte) [][]byte {
+			return lines[:2]
+		}, "log ends at entry 2"},
+		{"garbage", func(lines [][]byte) [][]byte {
+			return append(lines, []byte("\nnot json"))
+		}, "line 4: entry does not parse"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newLog(t, 3)
+			writeLines(t, l, tc.tamper(lines(t, l)))
+			problems := verify(t, l)
+			if len(problems) == 0 || !strings.HasPrefix(problems[0], tc.want) {
+				t.Errorf("problems = %q, want first to start with %q", problems, tc.want)
+			}
+		})
+	}
+}
+
+func TestAppendRefusesBrokenLog(t *testing.T) {
+	l := newLog(t, 2)
+	writeLines(t, l, lines(t, l)[:1])
+	if _, err := l.Append("run.start", nil); err == nil {
+		t.Fatal("appended to a truncated log")
+	}
+
+	l = newLog(t, 1)
+	if err := os.Remove(l.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append("run.start", nil); err == nil {
+		t.Fatal("appended to a deleted log")
+	}
+}
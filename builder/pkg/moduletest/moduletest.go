@@ -0,0 +1,253 @@
+// Package moduletest runs cloak modules against small fixture source trees
+// and compares the result with golden directories.
+//
+// Modules live in package main, so the harness does not know about the
+// Module type. Tests pass a RunFunc that runs the module for a run
+// directory, with the fixture checked out in its "sliver" subdirectory:
+//
+//	src := moduletest.Run(t, "testdata/fixtures/mini", func(runDir string) error {
+//		return NewBrandingModule().Run(&Config{RunDir: runDir}, false)
+//	})
+//	moduletest.CompareGolden(t, src, "testdata/golden/branding")
+//
+// Golden directories are rewritten from the result with 'go test -update'.
+package moduletest
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden directories with the current results")
+
+// SourceDir is the name of the source tree inside a run directory
+const SourceDir = "sliver"
+
+// RunFunc runs a module for the given run directory
+type RunFunc func(runDir string) error
+
+// Setup copies the fixture tree into a new temporary run directory and
+// returns the run directory
+func Setup(t testing.TB, fixture string) string {
+	t.Helper()
+	runDir := t.TempDir()
+	if err := CopyTree(fixture, filepath.Join(runDir, SourceDir)); err != nil {
+		t.Fatalf("failed to copy fixture %s: %v", fixture, err)
+	}
+	return runDir
+}
+
+// Run sets up the fixture, runs the module and returns the resulting
+// source tree. The test fails if the module returns an error.
+func Run(t testing.TB, fixture string, run RunFunc) string {
+	t.Helper()
+	runDir := Setup(t, fixture)
+	if err := run(runDir); err != nil {
+		t.Fatalf("module failed: %v", err)
+	}
+	return filepath.Join(runDir, SourceDir)
+}
+
+// CopyTree copies the files and directories under src to dst, keeping file
+// modes
+func CopyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// Files returns the slash-separated paths of all regular files under root
+func Files(t testing.TB, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list %s: %v", root, err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// CompareGolden compares the tree under got with the golden directory: the
+// same files must exist with the same contents and executable bits. With
+// -update the golden directory is replaced by got instead.
+func CompareGolden(t testing.TB, got, golden string) {
+	t.Helper()
+	if *update {
+		if err := os.RemoveAll(golden); err != nil {
+			t.Fatal(err)
+		}
+		if err := CopyTree(got, golden); err != nil {
+			t.Fatalf("failed to update golden %s: %v", golden, err)
+		}
+		return
+	}
+
+	AssertSameTree(t, got, golden)
+	if t.Failed() {
+		t.Log("run 'go test -update' to accept the new output")
+	}
+}
+
+// AssertSameTree checks that the trees under got and want hold the same
+// files with the same contents and executable bits. Unlike CompareGolden it
+// never writes to want, so it is safe to use with shared fixtures.
+func AssertSameTree(t testing.TB, got, want string) {
+	t.Helper()
+	gotFiles, wantFiles := Files(t, got), Files(t, want)
+	gotSet := make(map[string]bool)
+	for _, f := range gotFiles {
+		gotSet[f] = true
+	}
+	for _, f := range wantFiles {
+		if !gotSet[f] {
+			t.Errorf("missing file %s", f)
+			continue
+		}
+		delete(gotSet, f)
+
+		gotData := readFile(t, filepath.Join(got, f))
+		wantData := readFile(t, filepath.Join(want, f))
+		if !bytes.Equal(gotData, wantData) {
+			t.Errorf("%s differs from %s:\n--- got\n%s\n--- want\n%s", f, want, gotData, wantData)
+		}
+		if gotExec, wantExec := isExecutable(t, filepath.Join(got, f)), isExecutable(t, filepath.Join(want, f)); gotExec != wantExec {
+			t.Errorf("%s: executable is %v, want %v", f, gotExec, wantExec)
+		}
+	}
+	for _, f := range gotFiles {
+		if gotSet[f] {
+			t.Errorf("unexpected file %s", f)
+		}
+	}
+}
+
+// AssertRenamed checks that from no longer exists under root and to does
+func AssertRenamed(t testing.TB, root, from, to string) {
+	t.Helper()
+	if _, err := os.Lstat(filepath.Join(root, from)); err == nil {
+		t.Errorf("%s still exists, expected it to be renamed to %s", from, to)
+	}
+	if _, err := os.Lstat(filepath.Join(root, to)); err != nil {
+		t.Errorf("%s does not exist, expected %s to be renamed to it", to, from)
+	}
+}
+
+// AssertExists checks that every path exists under root
+func AssertExists(t testing.TB, root string, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if _, err := os.Lstat(filepath.Join(root, path)); err != nil {
+			t.Errorf("expected %s to exist: %v", path, err)
+		}
+	}
+}
+
+// AssertMode checks the permission bits of a file under root
+func AssertMode(t testing.TB, root, path string, want fs.FileMode) {
+	t.Helper()
+	info, err := os.Lstat(filepath.Join(root, path))
+	if err != nil {
+		t.Errorf("failed to stat %s: %v", path, err)
+		return
+	}
+	if got := info.Mode().Perm(); got != want {
+		t.Errorf("%s has mode %v, want %v", path, got, want)
+	}
+}
+
+// Count returns the number of occurrences of s in the contents of all files
+// under root
+func Count(t testing.TB, root, s string) int {
+	t.Helper()
+	n := 0
+	for _, f := range Files(t, root) {
+		n += bytes.Count(readFile(t, filepath.Join(root, f)), []byte(s))
+	}
+	return n
+}
+
+// AssertCount checks the number of occurrences of s in the files under root
+func AssertCount(t testing.TB, root, s string, want int) {
+	t.Helper()
+	if got := Count(t, root, s); got != want {
+		t.Errorf("found %q %d times, want %d", s, got, want)
+	}
+}
+
+// AssertNoMatch checks that no file name or content under root contains s
+func AssertNoMatch(t testing.TB, root, s string) {
+	t.Helper()
+	for _, f := range Files(t, root) {
+		if strings.Contains(f, s) {
+			t.Errorf("path %s contains %q", f, s)
+		}
+	}
+	if n := Count(t, root, s); n > 0 {
+		t.Errorf("found %q %d times in file contents", s, n)
+	}
+}
+
+func readFile(t testing.TB, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return data
+}
+
+func isExecutable(t testing.TB, path string) bool {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	return info.Mode().Perm()&0111 != 0
+}
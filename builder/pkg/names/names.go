@@ -0,0 +1,274 @@
+// Package names generates plausible replacement identifiers.
+//
+// A Generator is deterministic: the same seed, salt, wordlist and reserved
+// names always produce the same sequence of names. Names are valid Go
+// identifiers, follow the casing of the name they replace and never repeat
+// a reserved name or an earlier result, compared case-insensitively.
+//
+//	g := names.New(seed, "branding")
+//	if err := g.ReserveTree(srcDir); err != nil {
+//		return err
+//	}
+//	prefix := g.Word()              // e.g. "dravel"
+//	vendor := g.Like("BishopFox")   // e.g. "TorbinMaskel"
+package names
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Syllable model: onset, vowel and optional coda
+var (
+	onsets = []string{
+		"b", "c", "d", "f", "g", "h", "j", "k", "l", "m", "n", "p", "r", "s", "t", "v", "w", "z",
+		"br", "cr", "dr", "fr", "gr", "pr", "tr", "bl", "cl", "fl", "gl", "pl", "sl", "st", "ch", "sh", "th",
+	}
+	vowels = []string{"a", "e", "i", "o", "u", "a", "e", "o", "ai", "ea", "io", "ou"}
+	codas  = []string{"", "", "", "", "n", "r", "l", "s", "t", "x", "m", "nd", "rk", "st"}
+)
+
+// reservedWords are Go keywords and predeclared identifiers
+var reservedWords = strings.Fields(`
+	break case chan const continue default defer else fallthrough for func go
+	goto if import interface map package range return select struct switch type
+	var any append bool byte cap clear close comparable complex complex128
+	complex64 copy delete error false float32 float64 imag int int16 int32 int64
+	int8 iota len make max min new nil panic print println real recover rune
+	string true uint uint16 uint32 uint64 uint8 uintptr`)
+
+// identifier matches identifiers in source text
+var identifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// maxAttempts bounds the draws for a single name before it is lengthened
+const maxAttempts = 100
+
+// Generator produces replacement names
+type Generator struct {
+	rng   *rand.Rand
+	words []string
+	taken map[string]bool // lower case
+}
+
+// New returns a generator for seed. The salt, typically the module name,
+// gives every user of a seed its own independent sequence.
+func New(seed int64, salt string) *Generator {
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	g := &Generator{
+		rng:   rand.New(rand.NewSource(seed ^ int64(h.Sum64()))),
+		taken: make(map[string]bool),
+	}
+	g.Reserve(reservedWords...)
+	return g
+}
+
+// SetWords makes the generator draw words from a wordlist before falling
+// back to the syllable model. Words that are not letters only are dropped.
+func (g *Generator) SetWords(words []string) {
+	g.words = g.words[:0]
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" && isLetters(word) {
+			g.words = append(g.words, word)
+		}
+	}
+}
+
+// LoadWords reads a wordlist with one word per line, see SetWords. Empty
+// lines and lines starting with # are ignored.
+func (g *Generator) LoadWords(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read wordlist: %w", err)
+	}
+	var words []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	g.SetWords(words)
+	return scanner.Err()
+}
+
+// Reserve marks names the generator must not produce
+func (g *Generator) Reserve(names ...string) {
+	for _, name := range names {
+		g.taken[strings.ToLower(name)] = true
+	}
+}
+
+// Reserved reports whether name is reserved or was already produced
+func (g *Generator) Reserved(name string) bool {
+	return g.taken[strings.ToLower(name)]
+}
+
+// ReserveTree reserves every identifier in the text files under root, so
+// generated names do not collide with names already in the tree. Binary
+// files and .git are skipped.
+func (g *Generator) ReserveTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			return nil
+		}
+		for _, name := range identifier.FindAll(data, -1) {
+			g.taken[strings.ToLower(string(name))] = true
+		}
+		return nil
+	})
+}
+
+// Word returns a new lower case word
+func (g *Generator) Word() string {
+	return g.Like("name")
+}
+
+// Like returns a new name in the style of original: lower, Title, UPPER,
+// camelCase, PascalCase or snake_case, with as many words as original has.
+// The result is a valid Go identifier, exported if original is.
+func (g *Generator) Like(original string) string {
+	words := splitWords(original)
+	if len(words) == 0 {
+		words = []string{"name"}
+	}
+
+	for attempt := 0; ; attempt++ {
+		// Lengthen the words when the short ones are used up
+		extra := attempt / maxAttempts
+		parts := make([]string, len(words))
+		for i, word := range words {
+			parts[i] = applyCase(g.draw(extra), word)
+		}
+		sep := ""
+		if strings.Contains(original, "_") {
+			sep = "_"
+		}
+		name := strings.Join(parts, sep)
+		if !g.Reserved(name) {
+			g.Reserve(name)
+			return name
+		}
+	}
+}
+
+// draw returns a lower case word from the wordlist or the syllable model
+func (g *Generator) draw(extra int) string {
+	if len(g.words) > 0 && extra == 0 {
+		return g.words[g.rng.Intn(len(g.words))]
+	}
+	n := 2 + g.rng.Intn(2) + extra
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(onsets[g.rng.Intn(len(onsets))])
+		b.WriteString(vowels[g.rng.Intn(len(vowels))])
+		if i == n-1 {
+			b.WriteString(codas[g.rng.Intn(len(codas))])
+		}
+	}
+	return b.String()
+}
+
+// splitWords splits an identifier into words at case changes, underscores
+// and digits, e.g. "httpSessionInit" into "http", "Session", "Init"
+func splitWords(s string) []string {
+	var words []string
+	var cur []rune
+	runes := []rune(s)
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = nil
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && len(cur) > 0:
+			// Start a new word at aB, and at the last capital of ABc
+			prevLower := unicode.IsLower(cur[len(cur)-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				flush()
+			}
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return words
+}
+
+// applyCase gives word the casing of the original word
+func applyCase(word, original string) string {
+	switch {
+	case allUpper(original) && len(original) > 1:
+		return strings.ToUpper(word)
+	case unicode.IsUpper([]rune(original)[0]):
+		return strings.ToUpper(word[:1]) + word[1:]
+	}
+	return word
+}
+
+func allUpper(s string) bool {
+	hasLetter := false
+	for _, r := range s {
+		if unicode.IsLower(r) {
+			return false
+		}
+		hasLetter = hasLetter || unicode.IsLetter(r)
+	}
+	return hasLetter
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsIdentifier reports whether s is a valid Go identifier that is not a
+// keyword or predeclared name
+func IsIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(unicode.IsLetter(r) || r == '_' || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	for _, word := range reservedWords {
+		if s == word {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package names
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestDeterministic(t *testing.T) {
+	a, b := New(42, "branding"), New(42, "branding")
+	for i := 0; i < 50; i++ {
+		if x, y := a.Like("IfconfigReq"), b.Like("IfconfigReq"); x != y {
+			t.Fatalf("draw %d differs: %s vs %s", i, x, y)
+		}
+	}
+	if New(42, "branding").Word() == New(42, "Elastic").Word() {
+		t.Error("different salts produced the same first word")
+	}
+	if New(1, "branding").Word() == New(2, "branding").Word() {
+		t.Error("different seeds produced the same first word")
+	}
+}
+
+func TestLikeCasing(t *testing.T) {
+	g := New(7, "test")
+	for original, pattern := range map[string]string{
+		"sliver":          `^[a-z]+$`,
+		"Sliver":          `^[A-Z][a-z]+$`,
+		"SLIVER":          `^[A-Z]+$`,
+		"BishopFox":       `^[A-Z][a-z]+[A-Z][a-z]+$`,
+		"httpSessionInit": `^[a-z]+[A-Z][a-z]+[A-Z][a-z]+$`,
+		"RPC_TIMEOUT":     `^[A-Z]+_[A-Z]+$`,
+		"HTTPServer":      `^[A-Z]+[A-Z][a-z]+$`,
+	} {
+		name := g.Like(original)
+		if !regexp.MustCompile(pattern).MatchString(name) {
+			t.Errorf("Like(%s) = %s, want match for %s", original, name, pattern)
+		}
+		if !IsIdentifier(name) {
+			t.Errorf("Like(%s) = %s is not a valid identifier", original, name)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	for s, want := range map[string]int{
+		"sliver": 1, "IfconfigReq": 2, "InvokeMigrateReq": 3, "HTTPServer": 2, "RPC_TIMEOUT": 2, "-NoExit": 2,
+	} {
+		if got := splitWords(s); len(got) != want {
+			t.Errorf("splitWords(%s) = %v, want %d words", s, got, want)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	// With a one word list, every draw collides after the first and the
+	// generator must fall back to the syllable model
+	g := New(1, "test")
+	g.SetWords([]string{"falcon"})
+	g.Reserve("Falcon")
+	for i := 0; i < 20; i++ {
+		if name := g.Word(); name == "falcon" {
+			t.Fatal("generated a reserved name")
+		}
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc dravel() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g = New(1, "test")
+	if err := g.ReserveTree(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dravel", "Dravel", "main", "package"} {
+		if !g.Reserved(name) {
+			t.Errorf("%s is not reserved", name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 500; i++ {
+		name := g.Like("Sliver")
+		if seen[name] {
+			t.Fatalf("generated %s twice", name)
+		}
+		seen[name] = true
+	}
+}
+
+func TestIsIdentifier(t *testing.T) {
+	for s, want := range map[string]bool{
+		"gunner": true, "Gunner2": true, "_x": true, "2x": false, "": false, "func": false, "string": false, "a-b": false,
+	} {
+		if got := IsIdentifier(s); got != want {
+			t.Errorf("IsIdentifier(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Package structured edits JSON and YAML files by path.
+//
+// Documents are held as yaml.v3 node trees, for JSON as well as YAML, so key
+// order is kept and YAML comments survive an edit. Output uses the
+// indentation detected in the input.
+//
+//	doc, err := structured.Parse(data, structured.JSON)
+//	path, err := structured.ParsePath("$.implant_config.user_agent")
+//	n, err := doc.Set(path, structured.String("Mozilla/5.0"), false)
+//	data, err = doc.Bytes()
+package structured
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the syntax of a document
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// FormatOf returns the format of a file by its extension
+func FormatOf(name string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	}
+	return "", fmt.Errorf("unknown format of %s, expected .json, .yaml or .yml", name)
+}
+
+// ParseFormat returns the format named s
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case JSON:
+		return JSON, nil
+	case YAML, "yml":
+		return YAML, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected json or yaml", s)
+}
+
+// Document is a parsed JSON or YAML file
+type Document struct {
+	root    *yaml.Node // the content node, not the document node
+	doc     *yaml.Node // YAML only: the document node holding comments
+	format  Format
+	indent  string
+	newline bool // input ended with a newline
+}
+
+// Parse parses a JSON or YAML document. YAML input must hold a single
+// document.
+func Parse(data []byte, format Format) (*Document, error) {
+	d := &Document{
+		format:  format,
+		indent:  detectIndent(data),
+		newline: bytes.HasSuffix(data, []byte("\n")),
+	}
+	switch format {
+	case JSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		root, err := decodeJSON(dec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, errors.New("failed to parse JSON: data after the top-level value")
+		}
+		d.root = root
+
+	case YAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		var extra yaml.Node
+		if err := dec.Decode(&extra); err != io.EOF {
+			return nil, errors.New("YAML files with more than one document are not supported")
+		}
+		if len(doc.Content) != 1 {
+			return nil, errors.New("empty YAML document")
+		}
+		d.doc, d.root = &doc, doc.Content[0]
+
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return d, nil
+}
+
+// Format returns the format of the document
+func (d *Document) Format() Format {
+	return d.format
+}
+
+// Bytes encodes the document in its format
+func (d *Document) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	switch d.format {
+	case JSON:
+		if err := encodeJSON(&buf, d.root, d.indent, 0); err != nil {
+			return nil, err
+		}
+		if d.newline {
+			buf.WriteByte('\n')
+		}
+
+	case YAML:
+		enc := yaml.NewEncoder(&buf)
+		width := len(d.indent)
+		if width < 2 || strings.Contains(d.indent, "\t") {
+			width = 2
+		}
+		enc.SetIndent(width)
+		d.doc.Content[0] = d.root
+		if err := enc.Encode(d.doc); err != nil {
+			return nil, fmt.Errorf("failed to encode YAML: %w", err)
+		}
+		if err := enc.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode YAML: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// detectIndent returns the leading whitespace of the first indented line,
+// or "" if no line is indented
+func detectIndent(data []byte) string {
+	for _, line := range bytes.Split(data, []byte("\n"))[1:] {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(trimmed) > 0 && len(trimmed) < len(line) {
+			return string(line[:len(line)-len(trimmed)])
+		}
+	}
+	return ""
+}
+
+// decodeJSON reads the next JSON value into a node tree
+func decodeJSON(dec *json.Decoder) (*yaml.Node, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, String(key.(string)), value)
+			}
+			_, err := dec.Token()
+			return node, err
+		case '[':
+			node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for dec.More() {
+				value, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, value)
+			}
+			_, err := dec.Token()
+			return node, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	case string:
+		return String(t), nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(t.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: t.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(t)}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected token %v", token)
+}
+
+// encodeJSON writes a node tree as JSON, one member per line with indent,
+// or compact if indent is empty
+func encodeJSON(buf *bytes.Buffer, node *yaml.Node, indent string, depth int) error {
+	newline := func(depth int) {
+		if indent != "" {
+			buf.WriteByte('\n')
+			buf.WriteString(strings.Repeat(indent, depth))
+		}
+	}
+	separator := ":"
+	if indent != "" {
+		separator = ": "
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(depth + 1)
+			writeJSONString(buf, node.Content[i].Value)
+			buf.WriteString(separator)
+			if err := encodeJSON(buf, node.Content[i+1], indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		buf.WriteByte('}')
+
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(depth + 1)
+			if err := encodeJSON(buf, item, indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		buf.WriteByte(']')
+
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!str":
+			writeJSONString(buf, node.Value)
+		case "!!int", "!!float", "!!bool", "!!null":
+			buf.WriteString(node.Value)
+		default:
+			return fmt.Errorf("cannot encode %s value %q as JSON", node.Tag, node.Value)
+		}
+
+	case yaml.AliasNode:
+		return encodeJSON(buf, node.Alias, indent, depth)
+
+	default:
+		return fmt.Errorf("cannot encode node kind %v as JSON", node.Kind)
+	}
+	return nil
+}
+
+// writeJSONString writes s as a JSON string, leaving <, > and & unescaped
+func writeJSONString(buf *bytes.Buffer, s string) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	buf.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+// String returns a string scalar node
+func String(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
+
+// toJSON converts a YAML value into nodes that encode as valid JSON:
+// mapping keys become strings, aliases are expanded and scalars are
+// normalized through their decoded value
+func toJSON(node *yaml.Node) (*yaml.Node, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) != 1 {
+			return nil, errors.New("empty value")
+		}
+		return toJSON(node.Content[0])
+	case yaml.AliasNode:
+		return toJSON(node.Alias)
+	case yaml.MappingNode, yaml.SequenceNode:
+		out := &yaml.Node{Kind: node.Kind, Tag: "!!map"}
+		if node.Kind == yaml.SequenceNode {
+			out.Tag = "!!seq"
+		}
+		for i, child := range node.Content {
+			if node.Kind == yaml.MappingNode && i%2 == 0 {
+				out.Content = append(out.Content, String(child.Value))
+				continue
+			}
+			converted, err := toJSON(child)
+			if err != nil {
+				return nil, err
+			}
+			out.Content = append(out.Content, converted)
+		}
+		return out, nil
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("value %q has no JSON form: %w", node.Value, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeJSON(dec)
+}
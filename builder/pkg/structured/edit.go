@@ -0,0 +1,195 @@
+package structured
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotFound is returned when a path that is not optional matches nothing
+var ErrNotFound = errors.New("path not found")
+
+// match is a node found by a path, with where it sits in its parent
+type match struct {
+	parent *yaml.Node // nil for the root
+	index  int        // position of the node in parent.Content
+	node   *yaml.Node
+}
+
+// resolve returns the nodes path matches, in document order
+func (d *Document) resolve(path Path) []match {
+	matches := []match{{index: -1, node: d.root}}
+	for _, segment := range path {
+		var next []match
+		for _, m := range matches {
+			next = append(next, children(m.node, segment)...)
+		}
+		matches = next
+	}
+	return matches
+}
+
+// children returns the children of node selected by segment
+func children(node *yaml.Node, segment Segment) []match {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	var matches []match
+	switch {
+	case node.Kind == yaml.MappingNode && !segment.IsIndex:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if segment.Wildcard || node.Content[i].Value == segment.Key {
+				matches = append(matches, match{parent: node, index: i + 1, node: node.Content[i+1]})
+			}
+		}
+	case node.Kind == yaml.SequenceNode && segment.IsIndex:
+		if segment.Wildcard {
+			for i, child := range node.Content {
+				matches = append(matches, match{parent: node, index: i, node: child})
+			}
+			break
+		}
+		i := segment.Index
+		if i < 0 {
+			i += len(node.Content)
+		}
+		if i >= 0 && i < len(node.Content) {
+			matches = append(matches, match{parent: node, index: i, node: node.Content[i]})
+		}
+	}
+	return matches
+}
+
+// value prepares a value for insertion into the document
+func (d *Document) value(value *yaml.Node) (*yaml.Node, error) {
+	if d.format == JSON {
+		return toJSON(value)
+	}
+	if value.Kind == yaml.DocumentNode {
+		if len(value.Content) != 1 {
+			return nil, errors.New("empty value")
+		}
+		value = value.Content[0]
+	}
+	value = copyNode(value)
+	blockStyle(value)
+	return value, nil
+}
+
+// blockStyle makes flow style mappings and sequences, as values are often
+// written in the edits file, block style like the rest of a YAML document
+func blockStyle(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		node.Style &^= yaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// copyNode returns a deep copy of a node tree, so a value inserted in
+// several places can be edited independently
+func copyNode(node *yaml.Node) *yaml.Node {
+	out := *node
+	out.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		out.Content[i] = copyNode(child)
+	}
+	return &out
+}
+
+// Set replaces the nodes path matches with value and returns how many were
+// set. If nothing matches and the path is optional, a missing final mapping
+// key is created in every parent the rest of the path matches; otherwise
+// ErrNotFound is returned unless optional.
+func (d *Document) Set(path Path, value *yaml.Node, optional bool) (int, error) {
+	value, err := d.value(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %w", path, err)
+	}
+
+	matches := d.resolve(path)
+	for _, m := range matches {
+		if m.parent == nil {
+			d.root = copyNode(value)
+			continue
+		}
+		// Keep the key's comments with the key, not the replaced value
+		replacement := copyNode(value)
+		replacement.HeadComment, replacement.LineComment, replacement.FootComment = m.node.HeadComment, m.node.LineComment, m.node.FootComment
+		m.parent.Content[m.index] = replacement
+	}
+	if len(matches) > 0 || !optional {
+		return len(matches), notFound(path, len(matches))
+	}
+
+	last := path[len(path)-1]
+	if last.IsIndex || last.Wildcard {
+		return 0, nil
+	}
+	created := 0
+	for _, m := range d.resolve(path[:len(path)-1]) {
+		if m.node.Kind == yaml.MappingNode {
+			m.node.Content = append(m.node.Content, String(last.Key), copyNode(value))
+			created++
+		}
+	}
+	return created, nil
+}
+
+// Delete removes the nodes path matches, with their keys in mappings, and
+// returns how many were removed
+func (d *Document) Delete(path Path, optional bool) (int, error) {
+	if len(path) == 0 {
+		return 0, errors.New("cannot delete the document root")
+	}
+	matches := d.resolve(path)
+
+	// Remove from the back so earlier positions stay valid
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].index > matches[j].index
+	})
+	for _, m := range matches {
+		start := m.index
+		if m.parent.Kind == yaml.MappingNode {
+			start-- // the key
+		}
+		m.parent.Content = append(m.parent.Content[:start], m.parent.Content[m.index+1:]...)
+	}
+	if optional {
+		return len(matches), nil
+	}
+	return len(matches), notFound(path, len(matches))
+}
+
+// Append adds value to the end of the sequences path matches and returns
+// how many sequences were extended
+func (d *Document) Append(path Path, value *yaml.Node, optional bool) (int, error) {
+	value, err := d.value(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %w", path, err)
+	}
+
+	matches := d.resolve(path)
+	for _, m := range matches {
+		if m.node.Kind != yaml.SequenceNode {
+			return 0, fmt.Errorf("%s is not a list", path)
+		}
+	}
+	for _, m := range matches {
+		m.node.Content = append(m.node.Content, copyNode(value))
+	}
+	if optional {
+		return len(matches), nil
+	}
+	return len(matches), notFound(path, len(matches))
+}
+
+func notFound(path Path, n int) error {
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package structured
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// edit parses input, applies fn and returns the encoded result
+func edit(t *testing.T, input string, format Format, fn func(d *Document) error) string {
+	t.Helper()
+	d, err := Parse([]byte(input), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fn(d); err != nil {
+		t.Fatal(err)
+	}
+	out, err := d.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func path(t *testing.T, s string) Path {
+	t.Helper()
+	p, err := ParsePath(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// value parses a YAML value as written in an edits file
+func value(t *testing.T, s string) *yaml.Node {
+	t.Helper()
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &node); err != nil {
+		t.Fatal(err)
+	}
+	return &node
+}
+
+func TestJSONFormatting(t *testing.T) {
+	for _, tc := range []struct{ name, in, want string }{
+		{"tabs", "{\n\t\"b\": 1,\n\t\"a\": [true, null, 1.5e3, \"<&>\"]\n}\n",
+			"{\n\t\"b\": 2,\n\t\"a\": [\n\t\ttrue,\n\t\tnull,\n\t\t1.5e3,\n\t\t\"<&>\"\n\t]\n}\n"},
+		{"compact", `{"b":1,"a":{}}`, `{"b":2,"a":{}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := edit(t, tc.in, JSON, func(d *Document) error {
+				_, err := d.Set(path(t, "b"), value(t, "2"), false)
+				return err
+			})
+			if got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJSONValues(t *testing.T) {
+	got := edit(t, `{"a":[]}`, JSON, func(d *Document) error {
+		// YAML-only syntax is converted to JSON
+		for _, v := range []string{"0x10", "yes", "'0x10'", "~", "{b: [1, x], 2: c}", "&x {k: v}"} {
+			if _, err := d.Append(path(t, "a"), value(t, v), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	want := `{"a":[16,"yes","0x10",null,{"b":[1,"x"],"2":"c"},{"k":"v"}]}`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	d, err := Parse([]byte(`{"a":1}`), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Set(path(t, "a"), value(t, ".inf"), false); err == nil {
+		t.Error("set infinity in JSON")
+	}
+}
+
+func TestYAMLEdits(t *testing.T) {
+	in := `# profiles
+profiles:
+    a: {enabled: false}
+    b:
+        enabled: false # off
+list: [1, 2, 3]
+`
+	want := `# profiles
+profiles:
+    a: {enabled: true}
+    b:
+        enabled: true # off
+        name: new
+list: [1, 3]
+`
+	got := edit(t, in, YAML, func(d *Document) error {
+		if n, err := d.Set(path(t, "$.profiles.*.enabled"), value(t, "true"), false); n != 2 || err != nil {
+			t.Errorf("set matched %d: %v", n, err)
+		}
+		if n, err := d.Set(path(t, "$.profiles.b.name"), value(t, "new"), true); n != 1 || err != nil {
+			t.Errorf("optional set created %d: %v", n, err)
+		}
+		_, err := d.Delete(path(t, "$.list[-2]"), false)
+		return err
+	})
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := Parse([]byte("a: 1\n---\nb: 2\n"), YAML); err == nil {
+		t.Error("parsed a multi-document file")
+	}
+}
+
+func TestEditErrors(t *testing.T) {
+	d, err := Parse([]byte(`{"a":{"b":[1,2]},"c":"x"}`), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Set(path(t, "a.x.y"), value(t, "1"), false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("set missing path: %v", err)
+	}
+	if n, err := d.Set(path(t, "a.x.y"), value(t, "1"), true); n != 0 || err != nil {
+		t.Errorf("optional set below a missing parent: %d, %v", n, err)
+	}
+	if _, err := d.Delete(path(t, "a.b[5]"), false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete missing index: %v", err)
+	}
+	if n, err := d.Delete(path(t, "a.b[*]"), false); n != 2 || err != nil {
+		t.Errorf("delete all elements: %d, %v", n, err)
+	}
+	if _, err := d.Append(path(t, "c"), value(t, "1"), false); err == nil {
+		t.Error("appended to a string")
+	}
+	if _, err := d.Delete(path(t, "$"), false); err == nil {
+		t.Error("deleted the root")
+	}
+}
@@ -0,0 +1,144 @@
+package structured
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment is one step of a Path: a mapping key or a sequence index, either
+// of which may be a wildcard
+type Segment struct {
+	Key      string
+	Index    int // negative indexes count from the end
+	IsIndex  bool
+	Wildcard bool
+}
+
+func (s Segment) String() string {
+	switch {
+	case s.Wildcard && s.IsIndex:
+		return "[*]"
+	case s.Wildcard:
+		return ".*"
+	case s.IsIndex:
+		return "[" + strconv.Itoa(s.Index) + "]"
+	case isPlainKey(s.Key):
+		return "." + s.Key
+	}
+	return "[" + strconv.Quote(s.Key) + "]"
+}
+
+// Path addresses nodes of a document, in a JSONPath subset:
+//
+//	$.server.port              mapping keys
+//	$.paths[0], $.paths[-1]    sequence indexes, negative from the end
+//	$.headers[*].name          every element of a sequence
+//	$.profiles.*.enabled       every value of a mapping
+//	$["key.with.dots"]         quoted keys
+//
+// The leading $ may be left out.
+type Path []Segment
+
+func (p Path) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, s := range p {
+		b.WriteString(s.String())
+	}
+	return b.String()
+}
+
+// ParsePath parses a path, see Path
+func ParsePath(s string) (Path, error) {
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	if strings.HasPrefix(rest, "$") {
+		rest = rest[1:]
+	} else if !strings.HasPrefix(rest, "[") {
+		rest = "." + rest
+	}
+
+	var path Path
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			rest = rest[end+1:]
+			if key == "" {
+				return nil, fmt.Errorf("invalid path %q: empty key", s)
+			}
+			if key == "*" {
+				path = append(path, Segment{Wildcard: true})
+			} else {
+				path = append(path, Segment{Key: key})
+			}
+
+		case '[':
+			segment, n, err := parseBracket(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %w", s, err)
+			}
+			path = append(path, segment)
+			rest = rest[n:]
+
+		default:
+			return nil, fmt.Errorf("invalid path %q: unexpected %q", s, rest[0])
+		}
+	}
+	return path, nil
+}
+
+// parseBracket parses a [...] segment at the start of s and returns it with
+// its length
+func parseBracket(s string) (Segment, int, error) {
+	if len(s) > 1 && (s[1] == '"' || s[1] == '\'') {
+		quote := s[1]
+		end := 2
+		for end < len(s) && s[end] != quote {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end+1 >= len(s) || s[end+1] != ']' {
+			return Segment{}, 0, fmt.Errorf("unterminated quoted key")
+		}
+		key := s[2:end]
+		if quote == '"' {
+			unquoted, err := strconv.Unquote(s[1 : end+1])
+			if err != nil {
+				return Segment{}, 0, fmt.Errorf("invalid quoted key: %w", err)
+			}
+			key = unquoted
+		} else {
+			key = strings.ReplaceAll(key, `\'`, `'`)
+		}
+		return Segment{Key: key}, end + 2, nil
+	}
+
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return Segment{}, 0, fmt.Errorf("missing ]")
+	}
+	inner := strings.TrimSpace(s[1:end])
+	if inner == "*" {
+		return Segment{IsIndex: true, Wildcard: true}, end + 1, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return Segment{}, 0, fmt.Errorf("invalid index %q", inner)
+	}
+	return Segment{Index: index, IsIndex: true}, end + 1, nil
+}
+
+// isPlainKey reports whether a key can be written without quotes
+func isPlainKey(key string) bool {
+	return key != "" && key != "*" && !strings.ContainsAny(key, ".[]'\"")
+}
@@ -0,0 +1,34 @@
+package structured
+
+import "testing"
+
+func TestParsePath(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		segments int
+	}{
+		{"$", "$", 0},
+		{"$.server.port", "$.server.port", 2},
+		{"server.port", "$.server.port", 2},
+		{"[0].name", "$[0].name", 2},
+		{"$.paths[-1]", "$.paths[-1]", 2},
+		{"$.headers[*].name", "$.headers[*].name", 3},
+		{"$.profiles.*.enabled", "$.profiles.*.enabled", 3},
+		{`$["key.with.dots"]['it\'s'][ 2 ]`, `$["key.with.dots"]["it's"][2]`, 3},
+	} {
+		path, err := ParsePath(tc.in)
+		if err != nil {
+			t.Errorf("ParsePath(%q): %v", tc.in, err)
+			continue
+		}
+		if len(path) != tc.segments || path.String() != tc.want {
+			t.Errorf("ParsePath(%q) = %s (%d segments), want %s (%d)", tc.in, path, len(path), tc.want, tc.segments)
+		}
+	}
+
+	for _, in := range []string{"", "$..a", "$.a[", "$.a[x]", `$["a]`, "$a"} {
+		if path, err := ParsePath(in); err == nil {
+			t.Errorf("ParsePath(%q) = %s, want an error", in, path)
+		}
+	}
+}
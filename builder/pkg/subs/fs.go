@@ -0,0 +1,232 @@
+package subs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FS is the filesystem the subs functions operate on. Names are slash
+// separated and relative to the root of the filesystem, as in io/fs.
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	fs.ReadFileFS
+
+	// Lstat is Stat without following a final symlink
+	Lstat(name string) (fs.FileInfo, error)
+	// Readlink returns the target of a symlink
+	Readlink(name string) (string, error)
+	// Symlink creates newname as a symlink to oldname
+	Symlink(oldname, newname string) error
+	// WriteFile replaces the contents of a file, creating it with perm if
+	// it does not exist
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// Rename moves a file or directory
+	Rename(oldname, newname string) error
+	// MkdirAll creates a directory and any missing parents
+	MkdirAll(name string, perm fs.FileMode) error
+	// RemoveAll removes a file, symlink or directory and everything below
+	// it. A missing name is not an error, the root cannot be removed.
+	RemoveAll(name string) error
+	// Chmod changes the permission bits of a file or directory
+	Chmod(name string, mode fs.FileMode) error
+}
+
+// Errors returned for writes the filesystems refuse
+var (
+	ErrOutsideRoot = errors.New("path is outside the root")
+	ErrSymlink     = errors.New("path is a symlink")
+)
+
+// OSFS is an FS backed by a directory on disk. Writes are refused when a
+// symlink would take them outside the directory, and files are never
+// written or chmodded through a symlink.
+type OSFS struct {
+	dir string
+}
+
+// NewOSFS returns an FS rooted at dir
+func NewOSFS(dir string) *OSFS {
+	return &OSFS{dir: dir}
+}
+
+// Path returns the OS path of a name
+func (o *OSFS) Path(name string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(name))
+}
+
+func (o *OSFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return o.Path(name), nil
+}
+
+// writable returns the OS path of name for a write. The parent directory
+// must resolve to a directory inside the root, and with noLink name itself
+// must not be a symlink.
+func (o *OSFS) writable(op, name string, noLink bool) (string, error) {
+	p, err := o.path(op, name)
+	if err != nil || name == "." {
+		return p, err
+	}
+	root, err := filepath.EvalSymlinks(o.dir)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: ErrOutsideRoot}
+	}
+	if noLink {
+		if info, err := os.Lstat(p); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", &fs.PathError{Op: op, Path: name, Err: ErrSymlink}
+		}
+	}
+	return p, nil
+}
+
+func (o *OSFS) Open(name string) (fs.File, error) {
+	p, err := o.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (o *OSFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := o.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (o *OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := o.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (o *OSFS) ReadFile(name string) ([]byte, error) {
+	p, err := o.path("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func (o *OSFS) Lstat(name string) (fs.FileInfo, error) {
+	p, err := o.path("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+func (o *OSFS) Readlink(name string) (string, error) {
+	p, err := o.path("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	target, err := os.Readlink(p)
+	return filepath.ToSlash(target), err
+}
+
+func (o *OSFS) Symlink(oldname, newname string) error {
+	p, err := o.writable("symlink", newname, false)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(oldname), p)
+}
+
+// WriteFile writes to a temporary file next to the target and renames it
+// into place, so readers never see a partially written file
+func (o *OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := o.writable("writefile", name, true)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "temp_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Clean up in case of failure
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (o *OSFS) Rename(oldname, newname string) error {
+	oldPath, err := o.writable("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	newPath, err := o.writable("rename", newname, false)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// MkdirAll creates a directory and any missing parents. The deepest
+// existing parent must resolve to a directory inside the root.
+func (o *OSFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := o.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	existing := name
+	for existing != "." {
+		if _, err := os.Lstat(o.Path(existing)); err == nil {
+			break
+		}
+		existing = path.Dir(existing)
+	}
+	// writable checks the parent of its name, so ask about a child
+	if _, err := o.writable("mkdir", path.Join(existing, "_"), false); errors.Is(err, ErrOutsideRoot) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: ErrOutsideRoot}
+	}
+	return os.MkdirAll(p, perm)
+}
+
+// RemoveAll removes a file or directory and its contents. A symlink is
+// removed itself, never what it points to, and the root cannot be removed.
+func (o *OSFS) RemoveAll(name string) error {
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	p, err := o.writable("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+func (o *OSFS) Chmod(name string, mode fs.FileMode) error {
+	p, err := o.writable("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
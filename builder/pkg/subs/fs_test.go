@@ -0,0 +1,170 @@
+package subs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+// memTree returns a MemFS holding files (path -> content)
+func memTree(t *testing.T, files map[string]string) *MemFS {
+	t.Helper()
+	m := NewMemFS()
+	for name, content := range files {
+		if err := m.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func memFiles(t *testing.T, fsys fs.FS) []string {
+	t.Helper()
+	var files []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, name)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestMemFS(t *testing.T) {
+	m := memTree(t, map[string]string{
+		"a/b/c.txt": "c",
+		"a/d.txt":   "d",
+		"run.sh":    "#!/bin/sh\n",
+	})
+	if err := fstest.TestFS(m, "a/b/c.txt", "a/d.txt", "run.sh"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.WriteFile("missing/x.txt", nil, 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("write without parent: got %v, want ErrNotExist", err)
+	}
+
+	// Writing keeps the mode of an existing file
+	if err := m.Chmod("run.sh", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("run.sh", []byte("#!/bin/sh\nexit 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := m.Stat("run.sh"); info.Mode().Perm() != 0755 {
+		t.Errorf("run.sh has mode %v after write, want 0755", info.Mode().Perm())
+	}
+
+	if err := m.Rename("a", "e"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"e/b/c.txt", "e/d.txt", "run.sh"}
+	if got := memFiles(t, m); !equal(got, want) {
+		t.Errorf("files after rename = %v, want %v", got, want)
+	}
+	if info, err := m.Stat("e"); err != nil || !info.IsDir() || info.Mode().Perm() != 0755 {
+		t.Errorf("renamed directory: %v, %v", info, err)
+	}
+
+	if err := m.Rename("e/b", "e"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("rename onto existing directory: got %v, want ErrExist", err)
+	}
+	if err := m.Rename("e", "e/b/f"); err == nil {
+		t.Error("expected an error renaming a directory into itself")
+	}
+
+	if err := m.Symlink("run.sh", "e/link"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"e/b", "e/link", "missing"} {
+		if err := m.RemoveAll(name); err != nil {
+			t.Errorf("remove %s: %v", name, err)
+		}
+	}
+	want = []string{"e/d.txt", "run.sh"}
+	if got := memFiles(t, m); !equal(got, want) {
+		t.Errorf("files after remove = %v, want %v", got, want)
+	}
+	if err := m.RemoveAll("."); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("removing the root: got %v, want ErrInvalid", err)
+	}
+}
+
+func TestSearchAndReplaceFS(t *testing.T) {
+	m := memTree(t, map[string]string{
+		"a.go":        "sliver\r\nsliver sliver\r\n",
+		"b/c.txt":     "no match\n",
+		"vendor/d.go": "sliver\n",
+	})
+
+	if err := SearchAndReplaceFS(m, "sliver", "gunner", []string{"vendor"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"a.go":        "gunner\r\ngunner gunner\r\n",
+		"b/c.txt":     "no match\n",
+		"vendor/d.go": "sliver\n",
+	} {
+		if got, _ := m.ReadFile(name); string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSearchAndRenameFS(t *testing.T) {
+	m := memTree(t, map[string]string{
+		"sliver/client/sliver/main.go": "a",
+		"sliver/sliver.go":             "b",
+		"docs/sliver/sliver.md":        "c",
+	})
+	if err := m.Chmod("sliver/sliver.go", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SearchAndRenameFilesFS(m, "sliver", "gunner", []string{"docs"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := SearchAndRenameDirectoriesFS(m, "sliver", "gunner", []string{"docs"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"docs/sliver/sliver.md", "gunner/client/gunner/main.go", "gunner/gunner.go"}
+	if got := memFiles(t, m); !equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if info, _ := m.Stat("gunner/gunner.go"); info.Mode().Perm() != 0755 {
+		t.Errorf("gunner/gunner.go has mode %v, want 0755", info.Mode().Perm())
+	}
+
+	// The collision check runs before anything is renamed
+	m = memTree(t, map[string]string{"sliver/a.go": "a", "gunner/b.go": "b"})
+	if err := SearchAndRenameDirectoriesFS(m, "sliver", "gunner", nil, false); err == nil {
+		t.Fatal("expected an error for an existing destination")
+	}
+	if got := memFiles(t, m); !equal(got, []string{"gunner/b.go", "sliver/a.go"}) {
+		t.Errorf("files changed after failed rename: %v", got)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package subs
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ValidPattern reports whether pattern is a glob Match accepts: relative,
+// without . or .. elements, with valid path.Match syntax in every element
+func ValidPattern(pattern string) error {
+	if pattern == "" || path.IsAbs(pattern) {
+		return fmt.Errorf("invalid pattern %q: must be relative to the tree", pattern)
+	}
+	for _, element := range strings.Split(pattern, "/") {
+		switch element {
+		case "", ".", "..":
+			return fmt.Errorf("invalid pattern %q: empty, . or .. element", pattern)
+		}
+		if _, err := path.Match(element, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether a slash separated name matches pattern. Elements
+// match as in path.Match, and a ** element matches any number of
+// directories, including none.
+func Match(pattern, name string) bool {
+	return matchElements(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Glob returns the names in fsys that match pattern, in lexical order. The
+// contents of a matching directory are not listed, symlinks are not
+// followed and .git is never matched.
+func Glob(fsys fs.FS, pattern string) ([]string, error) {
+	if err := ValidPattern(pattern); err != nil {
+		return nil, err
+	}
+	var matches []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if Match(pattern, name) {
+			matches = append(matches, name)
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+		}
+		return nil
+	})
+	return matches, err
+}
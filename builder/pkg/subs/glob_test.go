@@ -0,0 +1,70 @@
+package subs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"docs", "docs", true},
+		{"docs", "docs/a.md", false},
+		{"*.md", "README.md", true},
+		{"*.md", "docs/a.md", false},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/a.md", true},
+		{"**/testdata", "server/c2/testdata", true},
+		{"server/**/testdata", "server/testdata", true},
+		{"server/**/testdata", "client/testdata", false},
+		{"server/**", "server/a/b.go", true},
+		{"server/?.go", "server/a.go", true},
+		{"server/[ab].go", "server/c.go", false},
+	} {
+		if got := Match(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	for _, pattern := range []string{"", "/etc", "../x", "a/../b", "a//b", "./a", "a/[", "a/"} {
+		if err := ValidPattern(pattern); err == nil {
+			t.Errorf("ValidPattern(%q) accepted", pattern)
+		}
+	}
+	for _, pattern := range []string{"a", "**/x", "a/*.go", "[ab]/c"} {
+		if err := ValidPattern(pattern); err != nil {
+			t.Errorf("ValidPattern(%q): %v", pattern, err)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	m := memTree(t, map[string]string{
+		".git/config":                "",
+		"docs/a.md":                  "",
+		"docs/testdata/b.md":         "",
+		"server/c2/testdata/x.json":  "",
+		"server/c2/c2.go":            "",
+		"server/testdata/config.yml": "",
+		"README.md":                  "",
+	})
+	for pattern, want := range map[string][]string{
+		"**/testdata": {"docs/testdata", "server/c2/testdata", "server/testdata"},
+		"**/*.md":     {"README.md", "docs/a.md", "docs/testdata/b.md"},
+		"docs":        {"docs"},
+		"**/config":   nil,
+		"missing/*":   nil,
+	} {
+		got, err := Glob(m, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Glob(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package subs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// SymlinkPolicy decides what SearchAndReplaceLinks does with symlinks
+type SymlinkPolicy string
+
+const (
+	// SymlinkSkip leaves symlinks alone. The files they point to are still
+	// rewritten when the walk reaches them.
+	SymlinkSkip SymlinkPolicy = "skip"
+	// SymlinkFollow rewrites the text of the file a symlink points to, as
+	// if the link were that file, and keeps the link. A target is rewritten
+	// at most once and only if it resolves inside the root.
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkRetarget rewrites the target path stored in the symlink, e.g.
+	// to follow a directory that is renamed
+	SymlinkRetarget SymlinkPolicy = "retarget"
+)
+
+// SymlinkPolicies lists the valid policies
+var SymlinkPolicies = []SymlinkPolicy{SymlinkSkip, SymlinkFollow, SymlinkRetarget}
+
+// ParseSymlinkPolicy returns the policy named s, where an empty string is
+// SymlinkSkip
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	if s == "" {
+		return SymlinkSkip, nil
+	}
+	for _, policy := range SymlinkPolicies {
+		if string(policy) == s {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid symlink policy %q", s)
+}
+
+// maxLinkHops bounds the symlinks followed while resolving a path
+const maxLinkHops = 40
+
+// Resolve follows the symlinks in name and returns the path it refers to.
+// It returns ErrOutsideRoot if any link leads outside the filesystem,
+// including absolute link targets.
+func Resolve(fsys FS, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "resolve", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved := "."
+	parts := strings.Split(name, "/")
+	hops := 0
+	for len(parts) > 0 {
+		next := path.Join(resolved, parts[0])
+		parts = parts[1:]
+		if next == ".." || strings.HasPrefix(next, "../") {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: ErrOutsideRoot}
+		}
+		if next == resolved {
+			continue
+		}
+
+		info, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > maxLinkHops {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: ErrOutsideRoot}
+		}
+		// The target is relative to the directory holding the link
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return resolved, nil
+}
+
+// retarget replaces searchStr in the target of the symlink name. The new
+// link is created next to the old one and renamed over it.
+func retarget(fsys FS, name, searchStr, replaceStr string) (bool, error) {
+	target, err := fsys.Readlink(name)
+	if err != nil {
+		return false, err
+	}
+	newTarget := strings.ReplaceAll(target, searchStr, replaceStr)
+	if newTarget == target {
+		return false, nil
+	}
+
+	tmp := path.Join(path.Dir(name), ".cloak-link-"+path.Base(name))
+	if err := fsys.Symlink(newTarget, tmp); err != nil {
+		return false, err
+	}
+	if err := fsys.Rename(tmp, name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,186 @@
+package subs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// linkTree returns a root with a symlink to a file outside it, a symlink to
+// a file in an ignored directory and a symlink into a directory named
+// after the search string
+func linkTree(t *testing.T) (root, outside string) {
+	t.Helper()
+	outside = writeTree(t, map[string]string{"secret.txt": "sliver\n"})
+	root = writeTree(t, map[string]string{
+		"vendor/lib.go":   "sliver\n",
+		"sliver/main.go":  "sliver\n",
+		"regular.txt":     "sliver\n",
+		"nested/keep.txt": "nothing\n",
+	})
+	for link, target := range map[string]string{
+		"escape.txt":     filepath.Join(outside, "secret.txt"),
+		"nested/up.txt":  "../../" + filepath.Base(outside) + "/secret.txt",
+		"lib.go":         "vendor/lib.go",
+		"main.go":        "sliver/main.go",
+		"outdir":         outside,
+		"nested/reg.txt": "../regular.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func assertLink(t *testing.T, root, name, want string) {
+	t.Helper()
+	got, err := os.Readlink(filepath.Join(root, name))
+	if err != nil {
+		t.Errorf("%s is no longer a symlink: %v", name, err)
+		return
+	}
+	if want != "" && got != want {
+		t.Errorf("%s points to %s, want %s", name, got, want)
+	}
+}
+
+func TestSearchAndReplaceLinks(t *testing.T) {
+	for _, policy := range SymlinkPolicies {
+		t.Run(string(policy), func(t *testing.T) {
+			root, outside := linkTree(t)
+			err := SearchAndReplaceLinks(NewOSFS(root), "sliver", "gunner", []string{"vendor"}, policy, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Nothing outside the root is touched, and links stay links
+			if got := readString(t, filepath.Join(outside, "secret.txt")); got != "sliver\n" {
+				t.Errorf("file outside the root was rewritten: %q", got)
+			}
+			for _, link := range []string{"escape.txt", "nested/up.txt", "lib.go", "main.go", "outdir", "nested/reg.txt"} {
+				assertLink(t, root, link, "")
+			}
+			if got := readString(t, filepath.Join(root, "regular.txt")); got != "gunner\n" {
+				t.Errorf("regular.txt = %q", got)
+			}
+
+			wantLib := "sliver\n"
+			if policy == SymlinkFollow {
+				wantLib = "gunner\n"
+			}
+			if got := readString(t, filepath.Join(root, "vendor/lib.go")); got != wantLib {
+				t.Errorf("vendor/lib.go = %q, want %q", got, wantLib)
+			}
+
+			wantMain := "sliver/main.go"
+			if policy == SymlinkRetarget {
+				wantMain = "gunner/main.go"
+			}
+			assertLink(t, root, "main.go", wantMain)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	m := memTree(t, map[string]string{"a/b.txt": "b"})
+	for link, target := range map[string]string{
+		"l1":   "a/b.txt",
+		"a/l2": "../l1",
+		"dir":  "a",
+		"up":   "../x",
+		"abs":  "/etc/passwd",
+		"loop": "loop",
+	} {
+		if err := m.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"a/b.txt":   "a/b.txt",
+		"l1":        "a/b.txt",
+		"a/l2":      "a/b.txt",
+		"dir/b.txt": "a/b.txt",
+		"dir/l2":    "a/b.txt",
+	} {
+		got, err := Resolve(m, name)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"up", "abs"} {
+		if _, err := Resolve(m, name); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Resolve(%s): got %v, want ErrOutsideRoot", name, err)
+		}
+	}
+	if _, err := Resolve(m, "loop"); err == nil {
+		t.Error("expected an error for a symlink loop")
+	}
+
+	if data, err := m.ReadFile("dir/l2"); err != nil || string(data) != "b" {
+		t.Errorf("ReadFile through symlinks = %q, %v", data, err)
+	}
+}
+
+func TestOSFSContainment(t *testing.T) {
+	root, outside := linkTree(t)
+	o := NewOSFS(root)
+
+	if err := o.WriteFile("outdir/new.txt", []byte("x"), 0644); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("write through a symlinked directory: got %v, want ErrOutsideRoot", err)
+	}
+	if err := o.WriteFile("escape.txt", []byte("x"), 0644); !errors.Is(err, ErrSymlink) {
+		t.Errorf("write to a symlink: got %v, want ErrSymlink", err)
+	}
+	if err := o.Chmod("escape.txt", 0600); !errors.Is(err, ErrSymlink) {
+		t.Errorf("chmod of a symlink: got %v, want ErrSymlink", err)
+	}
+	if err := o.Rename("outdir/secret.txt", "stolen.txt"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("rename out of a symlinked directory: got %v, want ErrOutsideRoot", err)
+	}
+	if err := o.MkdirAll("outdir/a/b", 0755); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("mkdir through a symlinked directory: got %v, want ErrOutsideRoot", err)
+	}
+	if err := o.MkdirAll("new/a/b", 0755); err != nil {
+		t.Errorf("mkdir inside the root: %v", err)
+	}
+	if err := o.RemoveAll("outdir/secret.txt"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("remove through a symlinked directory: got %v, want ErrOutsideRoot", err)
+	}
+	if err := o.RemoveAll("outdir"); err != nil {
+		t.Errorf("remove of a symlinked directory: %v", err)
+	}
+	if err := o.RemoveAll("."); err == nil {
+		t.Error("removing the root succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("a file was created outside the root")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("a directory was created outside the root")
+	}
+	if info, _ := os.Stat(filepath.Join(outside, "secret.txt")); info.Mode().Perm() != 0644 {
+		t.Errorf("file outside the root has mode %v", info.Mode().Perm())
+	}
+}
+
+func TestRenameSymlinks(t *testing.T) {
+	root, outside := linkTree(t)
+	if err := os.Chmod(filepath.Join(outside, "secret.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "sliver.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RenamePaths(NewOSFS(root), []Rule{{Search: "sliver", Replace: "gunner"}}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	assertLink(t, root, "gunner.txt", filepath.Join(outside, "secret.txt"))
+	if info, _ := os.Stat(filepath.Join(outside, "secret.txt")); info.Mode().Perm() != 0600 {
+		t.Errorf("renaming a symlink changed the mode of its target to %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,370 @@
+package subs
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MemFS is an in-memory FS. Directories are created implicitly for the
+// files they contain, or explicitly with MkdirAll. Symlinks are entries
+// with fs.ModeSymlink holding the target as data; Open, Stat and ReadFile
+// follow them within the filesystem. A MemFS is not safe for concurrent
+// use.
+type MemFS struct {
+	files memMap
+}
+
+// NewMemFS returns an empty in-memory FS
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(memMap)}
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	resolved, err := Resolve(m, name)
+	if err != nil {
+		return nil, err
+	}
+	return m.files.Open(resolved)
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	resolved, err := Resolve(m, name)
+	if err != nil {
+		return nil, err
+	}
+	return m.files.Stat(resolved)
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return m.files.ReadDir(name)
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	resolved, err := Resolve(m, name)
+	if err != nil {
+		return nil, err
+	}
+	return m.files.ReadFile(resolved)
+}
+
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	return m.files.Stat(name)
+}
+
+func (m *MemFS) Readlink(name string) (string, error) {
+	file, ok := m.files[name]
+	if !ok || file.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(file.Data), nil
+}
+
+func (m *MemFS) Symlink(oldname, newname string) error {
+	if err := m.checkParent("symlink", newname); err != nil {
+		return err
+	}
+	if _, err := m.Lstat(newname); err == nil {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	m.files[newname] = &memFile{
+		Data:    []byte(oldname),
+		Mode:    fs.ModeSymlink | 0777,
+		ModTime: time.Now(),
+	}
+	return nil
+}
+
+// MkdirAll creates a directory and any missing parents
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if file, ok := m.files[dir]; ok {
+			if !file.Mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+			continue
+		}
+		// Directories implied by their contents are made explicit
+		m.files[dir] = &memFile{Mode: fs.ModeDir | perm, ModTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := m.checkParent("writefile", name); err != nil {
+		return err
+	}
+	if info, err := m.Lstat(name); err == nil {
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: "writefile", Path: name, Err: ErrSymlink}
+		}
+		if info.IsDir() {
+			return &fs.PathError{Op: "writefile", Path: name, Err: fs.ErrExist}
+		}
+		perm = info.Mode().Perm()
+	}
+	m.files[name] = &memFile{
+		Data:    append([]byte(nil), data...),
+		Mode:    perm,
+		ModTime: time.Now(),
+	}
+	return nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	info, err := m.Lstat(oldname)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if err := m.checkParent("rename", newname); err != nil {
+		return err
+	}
+	if target, err := m.Lstat(newname); err == nil && (info.IsDir() || target.IsDir()) {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+
+	if !info.IsDir() {
+		m.files[newname] = m.files[oldname]
+		delete(m.files, oldname)
+		return nil
+	}
+	if newname == oldname || strings.HasPrefix(newname, oldname+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+	}
+
+	// Move the directory entry, if explicit, and everything below it
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == oldname || strings.HasPrefix(name, oldname+"/") {
+			m.files[newname+strings.TrimPrefix(name, oldname)] = m.files[name]
+			delete(m.files, name)
+		}
+	}
+	if _, ok := m.files[newname]; !ok {
+		m.files[newname] = &memFile{Mode: info.Mode(), ModTime: info.ModTime()}
+	}
+	return nil
+}
+
+// RemoveAll removes a file, symlink or directory and everything below it
+func (m *MemFS) RemoveAll(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	for file := range m.files {
+		if file == name || strings.HasPrefix(file, name+"/") {
+			delete(m.files, file)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	info, err := m.Lstat(name)
+	if err != nil {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return &fs.PathError{Op: "chmod", Path: name, Err: ErrSymlink}
+	}
+	file, ok := m.files[name]
+	if !ok {
+		// Implicit directory
+		file = &memFile{ModTime: info.ModTime()}
+		m.files[name] = file
+	}
+	file.Mode = info.Mode().Type() | mode.Perm()
+	return nil
+}
+
+// checkParent returns an error unless the parent of name is a directory
+func (m *MemFS) checkParent(op, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	parent := path.Dir(name)
+	if parent == "." {
+		return nil
+	}
+	info, err := m.Lstat(parent)
+	if err != nil || !info.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// memFile is a file, directory or symlink of a MemFS
+type memFile struct {
+	Data    []byte
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// memMap holds the entries of a MemFS by name. Directories without an
+// entry exist implicitly for the names below them. Its methods never
+// follow symlinks.
+type memMap map[string]*memFile
+
+// implicitDir stands for the directories that have no entry
+var implicitDir = &memFile{Mode: fs.ModeDir | 0555}
+
+func (m memMap) Stat(name string) (fs.FileInfo, error) {
+	info, err := m.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (m memMap) stat(op, name string) (*memInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if file, ok := m[name]; ok {
+		return &memInfo{name: path.Base(name), file: file}, nil
+	}
+	if name == "." {
+		return &memInfo{name: ".", file: implicitDir}, nil
+	}
+	prefix := name + "/"
+	for other := range m {
+		if strings.HasPrefix(other, prefix) {
+			return &memInfo{name: path.Base(name), file: implicitDir}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (m memMap) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := m.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	children := make(map[string]*memInfo)
+	for other, file := range m {
+		if other == "." || !strings.HasPrefix(other, prefix) {
+			continue
+		}
+		child, _, nested := strings.Cut(strings.TrimPrefix(other, prefix), "/")
+		if !nested {
+			children[child] = &memInfo{name: child, file: file}
+		} else if _, ok := children[child]; !ok {
+			if explicit, ok := m[prefix+child]; ok {
+				children[child] = &memInfo{name: child, file: explicit}
+			} else {
+				children[child] = &memInfo{name: child, file: implicitDir}
+			}
+		}
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, child)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m memMap) ReadFile(name string) ([]byte, error) {
+	info, err := m.stat("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte(nil), info.file.Data...), nil
+}
+
+func (m memMap) Open(name string) (fs.File, error) {
+	info, err := m.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := m.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &memDir{info: info, path: name, entries: entries}, nil
+	}
+	return &memOpenFile{info: info, path: name}, nil
+}
+
+// memInfo describes an entry of a MemFS, as fs.FileInfo and fs.DirEntry
+type memInfo struct {
+	name string
+	file *memFile
+}
+
+func (i *memInfo) Name() string               { return i.name }
+func (i *memInfo) Size() int64                { return int64(len(i.file.Data)) }
+func (i *memInfo) Mode() fs.FileMode          { return i.file.Mode }
+func (i *memInfo) Type() fs.FileMode          { return i.file.Mode.Type() }
+func (i *memInfo) ModTime() time.Time         { return i.file.ModTime }
+func (i *memInfo) IsDir() bool                { return i.file.Mode.IsDir() }
+func (i *memInfo) Sys() interface{}           { return nil }
+func (i *memInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// memOpenFile is an open regular file of a MemFS
+type memOpenFile struct {
+	info   *memInfo
+	path   string
+	offset int
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Close() error               { return nil }
+
+func (f *memOpenFile) Read(b []byte) (int, error) {
+	if f.offset >= len(f.info.file.Data) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.info.file.Data[f.offset:])
+	f.offset += n
+	return n, nil
+}
+
+// memDir is an open directory of a MemFS
+type memDir struct {
+	info    *memInfo
+	path    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if count > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && count < len(rest) {
+		rest = rest[:count]
+	}
+	d.offset += len(rest)
+	return append([]fs.DirEntry(nil), rest...), nil
+}
@@ -0,0 +1,297 @@
+package subs
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Rule replaces Search with Replace in file and directory names
+type Rule struct {
+	Search  string
+	Replace string
+}
+
+// apply applies every rule, in order, to a single name
+func apply(rules []Rule, name string) string {
+	for _, rule := range rules {
+		if rule.Search != "" {
+			name = strings.ReplaceAll(name, rule.Search, rule.Replace)
+		}
+	}
+	return name
+}
+
+// Rename moves one file or directory to a new name in the same directory.
+// From is the path at the time the rename is applied: renames are ordered
+// deepest first, so the parents of From still have their original names.
+type Rename struct {
+	From string
+	To   string
+	Dir  bool
+}
+
+// Conflict is a path that more than one entry would end up at
+type Conflict struct {
+	// Path is the path after all renames
+	Path string
+	// Sources are the original paths of the entries, including an entry
+	// that is already at Path and not renamed
+	Sources []string
+	// CaseOnly is set when the paths only collide on case-insensitive
+	// filesystems
+	CaseOnly bool
+}
+
+// ConflictError is returned when a rename plan has conflicts. Nothing has
+// been renamed when it is returned.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d rename conflict(s):", len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		fmt.Fprintf(&b, "\n  %s <- %s", c.Path, strings.Join(c.Sources, ", "))
+		if c.CaseOnly {
+			b.WriteString(" (differ only in case)")
+		}
+	}
+	return b.String()
+}
+
+// RenamePlan is the full set of renames for a tree, in the order they are
+// applied
+type RenamePlan struct {
+	Renames []Rename
+	// Paths maps the original path of every renamed entry, including
+	// entries moved along with a renamed parent, to its final path
+	Paths map[string]string
+}
+
+// PlanRenames computes the renames of files and directories whose names
+// match any of the rules. Rules are applied in order to every path
+// component. Every entry of the tree is checked against the final paths,
+// and a ConflictError listing all collisions is returned if two entries
+// would end up at the same path, ignoring case.
+func PlanRenames(fsys FS, rules []Rule, ignoreDirs []string) (*RenamePlan, error) {
+	return planRenames(fsys, rules, ignoreDirs, true, true)
+}
+
+// RenamePaths plans and applies the renames for rules, see PlanRenames
+func RenamePaths(fsys FS, rules []Rule, ignoreDirs []string, verbose bool) error {
+	plan, err := PlanRenames(fsys, rules, ignoreDirs)
+	if err != nil {
+		return err
+	}
+	return plan.Apply(fsys, verbose)
+}
+
+// planRenames plans renames of files, directories or both
+func planRenames(fsys FS, rules []Rule, ignoreDirs []string, files, dirs bool) (*RenamePlan, error) {
+	type entry struct {
+		path  string
+		final string
+		dir   bool
+	}
+	var entries []entry
+	finals := map[string]string{".": "."}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			if d.IsDir() && isIgnored(name, ignoreDirs) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		base := path.Base(name)
+		ignored := d.IsDir() && isIgnored(name, ignoreDirs)
+		if !ignored && ((d.IsDir() && dirs) || (!d.IsDir() && files)) {
+			base = apply(rules, base)
+		}
+		final := path.Join(finals[path.Dir(name)], base)
+		finals[name] = final
+		entries = append(entries, entry{path: name, final: final, dir: d.IsDir()})
+
+		// The contents of an ignored directory keep their names, so they
+		// cannot collide with anything but the directory itself
+		if ignored {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking directory tree: %w", err)
+	}
+
+	// Group the entries by final path, ignoring case
+	groups := make(map[string][]entry)
+	for _, e := range entries {
+		key := strings.ToLower(e.final)
+		groups[key] = append(groups[key], e)
+	}
+	var conflicts []Conflict
+	conflicted := make(map[string]bool)
+	for _, e := range entries {
+		group := groups[strings.ToLower(e.final)]
+		if len(group) < 2 || group[0].path != e.path {
+			continue
+		}
+		renamed := false
+		for _, g := range group {
+			renamed = renamed || g.path != g.final
+		}
+		// Case collisions already present upstream are not ours to fix
+		if !renamed {
+			continue
+		}
+		c := Conflict{Path: e.final, CaseOnly: true}
+		seen := make(map[string]bool)
+		for _, g := range group {
+			c.Sources = append(c.Sources, g.path)
+			if seen[g.final] {
+				c.CaseOnly = false
+			}
+			seen[g.final] = true
+		}
+		conflicts = append(conflicts, c)
+		conflicted[strings.ToLower(e.final)] = true
+	}
+
+	// Only report the topmost collision of a subtree, the entries below
+	// two merging directories collide as a consequence
+	reported := conflicts[:0]
+	for _, c := range conflicts {
+		top := true
+		for dir := path.Dir(c.Path); dir != "."; dir = path.Dir(dir) {
+			if conflicted[strings.ToLower(dir)] {
+				top = false
+				break
+			}
+		}
+		if top {
+			reported = append(reported, c)
+		}
+	}
+	if len(reported) > 0 {
+		return nil, &ConflictError{Conflicts: reported}
+	}
+
+	plan := &RenamePlan{Paths: make(map[string]string)}
+	byDepth := make(map[int][]Rename)
+	var depths []int
+	for _, e := range entries {
+		if e.path == e.final {
+			continue
+		}
+		plan.Paths[e.path] = e.final
+		newBase := path.Base(e.final)
+		if path.Base(e.path) == newBase {
+			continue
+		}
+		depth := strings.Count(e.path, "/")
+		if _, ok := byDepth[depth]; !ok {
+			depths = append(depths, depth)
+		}
+		byDepth[depth] = append(byDepth[depth], Rename{
+			From: e.path,
+			To:   path.Join(path.Dir(e.path), newBase),
+			Dir:  e.dir,
+		})
+	}
+
+	// Deepest first, so the parents of a path keep their names until its
+	// rename is applied
+	sort.Sort(sort.Reverse(sort.IntSlice(depths)))
+	for _, depth := range depths {
+		ordered, err := orderRenames(byDepth[depth])
+		if err != nil {
+			return nil, err
+		}
+		plan.Renames = append(plan.Renames, ordered...)
+	}
+	return plan, nil
+}
+
+// orderRenames orders renames within a directory level so that no rename
+// targets a path that is still to be moved away, e.g. "a" -> "aa" runs
+// after "aa" -> "aaaa"
+func orderRenames(pending []Rename) ([]Rename, error) {
+	var ordered []Rename
+	for len(pending) > 0 {
+		sources := make(map[string]bool)
+		for _, r := range pending {
+			sources[strings.ToLower(r.From)] = true
+		}
+		var blocked []Rename
+		for _, r := range pending {
+			if sources[strings.ToLower(r.To)] && !strings.EqualFold(r.From, r.To) {
+				blocked = append(blocked, r)
+				continue
+			}
+			ordered = append(ordered, r)
+		}
+		if len(blocked) == len(pending) {
+			var paths []string
+			for _, r := range blocked {
+				paths = append(paths, r.From)
+			}
+			return nil, fmt.Errorf("renames form a cycle: %s", strings.Join(paths, ", "))
+		}
+		pending = blocked
+	}
+	return ordered, nil
+}
+
+// Apply performs the renames of the plan, preserving permissions. It stops
+// at the first error, and refuses to overwrite an existing path in case the
+// tree changed since the plan was made.
+func (p *RenamePlan) Apply(fsys FS, verbose bool) error {
+	for _, r := range p.Renames {
+		if verbose {
+			kind := "file"
+			if r.Dir {
+				kind = "directory with all contents"
+			}
+			log.Printf("Renaming %s: %s -> %s", kind, displayPath(fsys, r.From), displayPath(fsys, r.To))
+		}
+
+		info, err := fsys.Lstat(r.From)
+		if err != nil {
+			return fmt.Errorf("failed to rename %s: %w", r.From, err)
+		}
+		// A change of case only finds itself on case-insensitive filesystems
+		if _, err := fsys.Lstat(r.To); err == nil && !strings.EqualFold(r.From, r.To) {
+			return fmt.Errorf("destination path already exists: %s", displayPath(fsys, r.To))
+		}
+		if err := fsys.Rename(r.From, r.To); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", r.From, r.To, err)
+		}
+		// Symlinks have no permissions of their own
+		if info.Mode()&fs.ModeSymlink != 0 {
+			continue
+		}
+		if err := fsys.Chmod(r.To, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to restore permissions for %s: %w", r.To, err)
+		}
+	}
+	return nil
+}
+
+// isIgnored reports whether a directory is excluded from renames
+func isIgnored(name string, ignoreDirs []string) bool {
+	for _, ignoreDir := range ignoreDirs {
+		if strings.HasSuffix(name, ignoreDir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package subs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPlanRenames(t *testing.T) {
+	m := memTree(t, map[string]string{
+		"sliver/beacon/sliver_beacon.go": "a",
+		"sliver/x.go":                    "b",
+		"docs/sliver.md":                 "c",
+	})
+	rules := []Rule{{Search: "sliver", Replace: "gunner"}, {Search: "beacon", Replace: "lazer"}}
+
+	plan, err := PlanRenames(m, rules, []string{"docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for from, want := range map[string]string{
+		"sliver":                         "gunner",
+		"sliver/beacon":                  "gunner/lazer",
+		"sliver/beacon/sliver_beacon.go": "gunner/lazer/gunner_lazer.go",
+		"sliver/x.go":                    "gunner/x.go",
+	} {
+		if got := plan.Paths[from]; got != want {
+			t.Errorf("%s is renamed to %q, want %q", from, got, want)
+		}
+	}
+	if _, ok := plan.Paths["docs/sliver.md"]; ok {
+		t.Error("ignored file is renamed")
+	}
+
+	if err := plan.Apply(m, false); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"docs/sliver.md", "gunner/lazer/gunner_lazer.go", "gunner/x.go"}
+	if got := memFiles(t, m); !equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestPlanRenamesConflicts(t *testing.T) {
+	m := memTree(t, map[string]string{
+		"sliver.go":          "a",
+		"gunner.go":          "b",
+		"Gunner/a.go":        "c",
+		"sliver/a.go":        "d",
+		"sliver/b.go":        "e",
+		"pkg/sliver_test.go": "f",
+		"pkg/gunner_test.go": "g",
+		"pkg/other.go":       "h",
+	})
+
+	_, err := PlanRenames(m, []Rule{{Search: "sliver", Replace: "gunner"}}, nil)
+	var conflicts *ConflictError
+	if !errors.As(err, &conflicts) {
+		t.Fatalf("expected a ConflictError, got %v", err)
+	}
+
+	got := make(map[string]Conflict)
+	for _, c := range conflicts.Conflicts {
+		got[c.Path] = c
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 conflicts, got %v", err)
+	}
+	if c := got["gunner.go"]; len(c.Sources) != 2 || c.CaseOnly {
+		t.Errorf("gunner.go conflict: %+v", c)
+	}
+	if c := got["pkg/gunner_test.go"]; len(c.Sources) != 2 {
+		t.Errorf("pkg/gunner_test.go conflict: %+v", c)
+	}
+	// The directory collides with Gunner on case-insensitive filesystems;
+	// the files below it are not reported separately
+	c, ok := got["Gunner"]
+	if !ok {
+		c = got["gunner"]
+	}
+	if len(c.Sources) != 2 || !c.CaseOnly {
+		t.Errorf("gunner directory conflict: %+v", c)
+	}
+
+	// Nothing was renamed
+	want := []string{"Gunner/a.go", "gunner.go", "pkg/gunner_test.go", "pkg/other.go", "pkg/sliver_test.go", "sliver.go", "sliver/a.go", "sliver/b.go"}
+	if files := memFiles(t, m); !equal(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+}
+
+func TestPlanRenamesOrder(t *testing.T) {
+	// "x" -> "xx" must wait until "xx" -> "xxxx" moved out of the way
+	m := memTree(t, map[string]string{"x": "1", "xx": "2"})
+	if err := RenamePaths(m, []Rule{{Search: "x", Replace: "xx"}}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"xx": "1", "xxxx": "2"} {
+		if got, _ := m.ReadFile(name); string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSearchAndRenameFilesCollision(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"sliver.go": "a",
+		"gunner.go": "b",
+	})
+	if err := SearchAndRenameFiles(root, "sliver", "gunner", nil, false); err == nil {
+		t.Fatal("expected an error for an existing destination")
+	}
+	if got := readString(t, root+"/gunner.go"); got != "b" {
+		t.Errorf("gunner.go was overwritten with %q", got)
+	}
+}
@@ -2,256 +2,204 @@ package subs
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
-	"os"
-	"path/filepath"
-	"sort"
+	"path"
 	"strings"
 )
 
 // SearchAndReplace recursively searchers file content for the searchStr
 // and replaces with replaceStr, while preserving file permissions
 func SearchAndReplace(rootDir, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
-	return filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+	return SearchAndReplaceFS(NewOSFS(rootDir), searchStr, replaceStr, ignoreDirs, verbose)
+}
+
+// SearchAndRenameFiles recursively searches for and renames files with paths that match
+// searchStr, while preserving the original file's file permissions
+func SearchAndRenameFiles(rootDir, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
+	return SearchAndRenameFilesFS(NewOSFS(rootDir), searchStr, replaceStr, ignoreDirs, verbose)
+}
+
+// SearchAndRenameDirectories recursively searches for and renames directories that match searchStr
+func SearchAndRenameDirectories(rootDir, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
+	return SearchAndRenameDirectoriesFS(NewOSFS(rootDir), searchStr, replaceStr, ignoreDirs, verbose)
+}
+
+// SearchAndReplaceFS is SearchAndReplace for the whole of fsys. Symlinks
+// are skipped.
+func SearchAndReplaceFS(fsys FS, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
+	return SearchAndReplaceLinks(fsys, searchStr, replaceStr, ignoreDirs, SymlinkSkip, verbose)
+}
+
+// SearchAndReplaceLinks is SearchAndReplaceFS with a policy for symlinks.
+// Whatever the policy, nothing outside the root of fsys is modified and
+// symlinks stay symlinks.
+func SearchAndReplaceLinks(fsys FS, searchStr, replaceStr string, ignoreDirs []string, links SymlinkPolicy, verbose bool) error {
+	var symlinks []string
+	visited := make(map[string]bool)
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
 		// Handle directories
-		if info.IsDir() {
+		if d.IsDir() {
 			// Check if this directory should be ignored
-			baseName := filepath.Base(path)
+			baseName := path.Base(name)
 			for _, ignoreDir := range ignoreDirs {
 				if ignoreDir != "" && baseName == ignoreDir {
-					return filepath.SkipDir
+					return fs.SkipDir
 				}
 			}
 			return nil
 		}
 
-		// Read file content
-		content, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("error reading file %s: %v", path, err)
-		}
-
-		// Check if file contains the search string
-		if !strings.Contains(string(content), searchStr) {
+		if d.Type()&fs.ModeSymlink != 0 {
+			symlinks = append(symlinks, name)
 			return nil
 		}
-
-		// Get the original file permissions
-		fileMode := info.Mode()
-
-		// Create a temporary file
-		tempFile, err := os.CreateTemp(filepath.Dir(path), "temp_*")
-		if err != nil {
-			return fmt.Errorf("error creating temp file for %s: %v", path, err)
+		if !d.Type().IsRegular() {
+			return nil
 		}
-		tempFilePath := tempFile.Name()
-		defer os.Remove(tempFilePath) // Clean up in case of failure
-
-		// Process the file line by line to maintain original line endings
-		reader := bufio.NewReader(strings.NewReader(string(content)))
-		writer := bufio.NewWriter(tempFile)
-		modified := false
-
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil && err != io.EOF {
-				tempFile.Close()
-				return fmt.Errorf("error reading line from %s: %v", path, err)
-			}
+		visited[name] = true
+		return replaceFile(fsys, name, searchStr, replaceStr, verbose)
+	})
+	if err != nil {
+		return err
+	}
 
-			if strings.Contains(line, searchStr) {
-				line = strings.ReplaceAll(line, searchStr, replaceStr)
-				modified = true
+	for _, name := range symlinks {
+		switch links {
+		case SymlinkFollow:
+			target, err := Resolve(fsys, name)
+			if errors.Is(err, ErrOutsideRoot) {
+				log.Printf("Warning: skipping symlink %s: it points outside the root", displayPath(fsys, name))
+				continue
 			}
-
-			if _, err := writer.WriteString(line); err != nil {
-				tempFile.Close()
-				return fmt.Errorf("error writing to temp file for %s: %v", path, err)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // dangling
 			}
-
-			if err == io.EOF {
-				break
+			if err != nil {
+				return fmt.Errorf("error resolving symlink %s: %v", name, err)
 			}
-		}
-
-		if err := writer.Flush(); err != nil {
-			tempFile.Close()
-			return fmt.Errorf("error flushing temp file for %s: %v", path, err)
-		}
-		tempFile.Close()
-
-		// Only replace the original file if modifications were made
-		if modified {
-			// Set the same permissions on the temp file before renaming
-			if err := os.Chmod(tempFilePath, fileMode); err != nil {
-				return fmt.Errorf("error setting permissions on temp file for %s: %v", path, err)
+			info, err := fsys.Lstat(target)
+			if err != nil {
+				return fmt.Errorf("error reading file info for %s: %v", target, err)
 			}
-
-			if err := os.Rename(tempFilePath, path); err != nil {
-				return fmt.Errorf("error replacing original file %s: %v", path, err)
+			if visited[target] || !info.Mode().IsRegular() {
+				continue
 			}
-			if verbose {
-				log.Printf("Modified file: %s\n", path)
+			visited[target] = true
+			if err := replaceFile(fsys, target, searchStr, replaceStr, verbose); err != nil {
+				return err
 			}
-		} else {
-			// Clean up temp file if no modifications were needed
-			os.Remove(tempFilePath)
-		}
 
-		return nil
-	})
-}
-
-// SearchAndRenameFiles recursively searches for and renames files with paths that match
-// searchStr, while preserving the original file's file permissions
-func SearchAndRenameFiles(rootDir, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
-	// Walk through all files and directories under rootDir
-	return filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip if it's a directory
-		if info.IsDir() {
-			// Check if this directory should be ignored
-			for _, ignoreDir := range ignoreDirs {
-				if strings.HasSuffix(path, ignoreDir) {
-					return filepath.SkipDir
-				}
+		case SymlinkRetarget:
+			changed, err := retarget(fsys, name, searchStr, replaceStr)
+			if err != nil {
+				return fmt.Errorf("error retargeting symlink %s: %v", name, err)
 			}
-			return nil
-		}
-
-		// Get the directory and filename separately
-		dir, filename := filepath.Split(path)
-
-		// Check if filename contains the search string
-		if strings.Contains(filename, searchStr) {
-			// Create the new filename with the replaced string
-			newFilename := strings.Replace(filename, searchStr, replaceStr, -1)
-			newPath := filepath.Join(dir, newFilename)
-
-			// Log the renaming operation
-			if verbose {
-				log.Printf("Renaming file: %s -> %s", path, newPath)
+			if changed && verbose {
+				log.Printf("Retargeted symlink: %s\n", displayPath(fsys, name))
 			}
 
-			// Get the current file permissions
-			fileMode := info.Mode()
-
-			// Perform the rename operation
-			if err := os.Rename(path, newPath); err != nil {
-				return fmt.Errorf("failed to rename file %s to %s: %w", path, newPath, err)
+		case SymlinkSkip:
+			if verbose {
+				log.Printf("Skipping symlink: %s\n", displayPath(fsys, name))
 			}
 
-			// Restore the file permissions
-			if err := os.Chmod(newPath, fileMode); err != nil {
-				return fmt.Errorf("failed to restore permissions for %s: %w", newPath, err)
-			}
+		default:
+			return fmt.Errorf("invalid symlink policy %q", links)
 		}
-
-		return nil
-	})
+	}
+	return nil
 }
 
-// SearchAndRenameDirectories recursively searches for and renames directories that match searchStr
-func SearchAndRenameDirectories(rootDir, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
-	// Clean and get absolute path for proper comparison
-	absRootDir, err := filepath.Abs(filepath.Clean(rootDir))
+// replaceFile replaces searchStr in a regular file, preserving its
+// permissions
+func replaceFile(fsys FS, name, searchStr, replaceStr string, verbose bool) error {
+	// Read file content
+	content, err := fsys.ReadFile(name)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for root directory: %w", err)
+		return fmt.Errorf("error reading file %s: %v", name, err)
 	}
 
-	type dirInfo struct {
-		path    string
-		depth   int
-		mode    os.FileMode
-		newPath string
+	// Check if file contains the search string
+	if !strings.Contains(string(content), searchStr) {
+		return nil
 	}
-	var dirs []dirInfo
-
-	// First pass: collect all directories that need to be renamed
-	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
 
-		// Skip if it's not a directory
-		if !info.IsDir() {
-			return nil
-		}
+	// Get the original file permissions
+	info, err := fsys.Lstat(name)
+	if err != nil {
+		return fmt.Errorf("error reading file info for %s: %v", name, err)
+	}
 
-		// Check if this directory should be ignored
-		for _, ignoreDir := range ignoreDirs {
-			if strings.HasSuffix(path, ignoreDir) {
-				return filepath.SkipDir
-			}
-		}
+	// Process the file line by line to maintain original line endings
+	reader := bufio.NewReader(strings.NewReader(string(content)))
+	var out strings.Builder
+	modified := false
 
-		// Get absolute path for comparison
-		absPath, err := filepath.Abs(filepath.Clean(path))
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path: %w", err)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading line from %s: %v", name, err)
 		}
 
-		// Skip if this is the root directory itself
-		if absPath == absRootDir {
-			return nil
+		if strings.Contains(line, searchStr) {
+			line = strings.ReplaceAll(line, searchStr, replaceStr)
+			modified = true
 		}
+		out.WriteString(line)
 
-		base := filepath.Base(path)
-		if strings.Contains(base, searchStr) {
-			depth := len(strings.Split(path, string(os.PathSeparator)))
-			newName := strings.Replace(base, searchStr, replaceStr, -1)
-			newPath := filepath.Join(filepath.Dir(path), newName)
-
-			// Check if destination already exists
-			if _, err := os.Stat(newPath); err == nil {
-				return fmt.Errorf("destination path already exists: %s", newPath)
-			}
-
-			dirs = append(dirs, dirInfo{
-				path:    path,
-				depth:   depth,
-				mode:    info.Mode(),
-				newPath: newPath,
-			})
+		if err == io.EOF {
+			break
 		}
+	}
 
+	// Only replace the original file if modifications were made
+	if !modified {
 		return nil
-	})
+	}
+	if err := fsys.WriteFile(name, []byte(out.String()), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("error replacing original file %s: %v", name, err)
+	}
+	if verbose {
+		log.Printf("Modified file: %s\n", displayPath(fsys, name))
+	}
+	return nil
+}
 
+// SearchAndRenameFilesFS is SearchAndRenameFiles for the whole of fsys.
+// Nothing is renamed if two files would end up with the same name.
+func SearchAndRenameFilesFS(fsys FS, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
+	plan, err := planRenames(fsys, []Rule{{Search: searchStr, Replace: replaceStr}}, ignoreDirs, true, false)
 	if err != nil {
-		return fmt.Errorf("error walking directory tree: %w", err)
+		return err
 	}
+	return plan.Apply(fsys, verbose)
+}
 
-	// Sort directories by depth in descending order (deepest first)
-	sort.Slice(dirs, func(i, j int) bool {
-		return dirs[i].depth > dirs[j].depth
-	})
-
-	// Second pass: rename directories from deepest to shallowest
-	for _, dir := range dirs {
-		if verbose {
-			log.Printf("Renaming directory with all contents: %s -> %s", dir.path, dir.newPath)
-		}
-
-		// Perform the atomic rename/move operation
-		if err := os.Rename(dir.path, dir.newPath); err != nil {
-			return fmt.Errorf("failed to rename directory %s to %s: %w", dir.path, dir.newPath, err)
-		}
-
-		// Restore the directory permissions
-		if err := os.Chmod(dir.newPath, dir.mode); err != nil {
-			return fmt.Errorf("failed to restore permissions for %s: %w", dir.newPath, err)
-		}
+// SearchAndRenameDirectoriesFS is SearchAndRenameDirectories for the whole
+// of fsys. Nothing is renamed if two directories would end up with the
+// same name.
+func SearchAndRenameDirectoriesFS(fsys FS, searchStr, replaceStr string, ignoreDirs []string, verbose bool) error {
+	plan, err := planRenames(fsys, []Rule{{Search: searchStr, Replace: replaceStr}}, ignoreDirs, false, true)
+	if err != nil {
+		return err
 	}
+	return plan.Apply(fsys, verbose)
+}
 
-	return nil
+// displayPath returns name as an OS path for filesystems backed by disk, so
+// log messages point at real files
+func displayPath(fsys FS, name string) string {
+	if o, ok := fsys.(*OSFS); ok {
+		return o.Path(name)
+	}
+	return name
 }
@@ -0,0 +1,132 @@
+package subs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+// writeTree creates files (path -> content) under a new temporary directory
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSearchAndReplace(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.go":           "sliver\r\nsliver sliver\r\n",
+		"b/c.txt":        "no match\n",
+		"vendor/d.go":    "sliver\n",
+		"nested/e.txt":   "last line without newline sliver",
+		"run.sh":         "#!/bin/sh\necho sliver\n",
+		"vendorish/f.go": "sliver\n",
+	})
+	if err := os.Chmod(filepath.Join(root, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SearchAndReplace(root, "sliver", "gunner", []string{"vendor"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		"a.go":           "gunner\r\ngunner gunner\r\n",
+		"b/c.txt":        "no match\n",
+		"vendor/d.go":    "sliver\n",
+		"nested/e.txt":   "last line without newline gunner",
+		"run.sh":         "#!/bin/sh\necho gunner\n",
+		"vendorish/f.go": "gunner\n",
+	} {
+		if got := readString(t, filepath.Join(root, path)); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	moduletest.AssertMode(t, root, "run.sh", 0755)
+
+	// No temporary files are left behind
+	if files := moduletest.Files(t, root); len(files) != 6 {
+		t.Errorf("expected 6 files, got %v", files)
+	}
+}
+
+func TestSearchAndRenameFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"sliver.go":          "a",
+		"cmd/sliver-cli.go":  "b",
+		"cmd/other.go":       "c",
+		"docs/sliver.md":     "d",
+		"sliver/sliver_x.go": "e",
+	})
+	if err := os.Chmod(filepath.Join(root, "cmd/sliver-cli.go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SearchAndRenameFiles(root, "sliver", "gunner", []string{"docs"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	moduletest.AssertRenamed(t, root, "sliver.go", "gunner.go")
+	moduletest.AssertRenamed(t, root, "cmd/sliver-cli.go", "cmd/gunner-cli.go")
+	moduletest.AssertRenamed(t, root, "sliver/sliver_x.go", "sliver/gunner_x.go")
+	moduletest.AssertExists(t, root, "cmd/other.go", "docs/sliver.md")
+	moduletest.AssertMode(t, root, "cmd/gunner-cli.go", 0755)
+	if got := readString(t, filepath.Join(root, "cmd/gunner-cli.go")); got != "b" {
+		t.Errorf("renamed file has content %q", got)
+	}
+}
+
+func TestSearchAndRenameDirectories(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"sliver/client/sliver/main.go": "a",
+		"sliver/server/x.go":           "b",
+		"docs/sliver/index.md":         "c",
+		"sliverpb/y.proto":             "d",
+	})
+
+	if err := SearchAndRenameDirectories(root, "sliver", "gunner", []string{"docs"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	moduletest.AssertExists(t, root,
+		"gunner/client/gunner/main.go",
+		"gunner/server/x.go",
+		"docs/sliver/index.md",
+		"gunnerpb/y.proto",
+	)
+	if files := moduletest.Files(t, root); len(files) != 4 {
+		t.Errorf("expected 4 files, got %v", files)
+	}
+}
+
+func TestSearchAndRenameDirectoriesCollision(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"sliver/a.go": "a",
+		"gunner/b.go": "b",
+	})
+
+	if err := SearchAndRenameDirectories(root, "sliver", "gunner", nil, false); err == nil {
+		t.Fatal("expected an error for an existing destination")
+	}
+	moduletest.AssertExists(t, root, "sliver/a.go", "gunner/b.go")
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultMakeTargets are run in order when a profile does not list any
+var DefaultMakeTargets = []string{"pb", "default"}
+
+// Profile describes a complete build. Profiles are YAML files and may extend
+// another profile, which they are layered on top of.
+type Profile struct {
+	Extends      string                 `yaml:"extends,omitempty"` // relative to the profile's directory
+	Source       string                 `yaml:"source,omitempty"`  // repository URL
+	Ref          string                 `yaml:"ref,omitempty"`     // 1.5, 1.6 or any git ref
+	Modules      []ProfileModule        `yaml:"modules,omitempty"`
+	Params       map[string]ParamValues `yaml:"params,omitempty"` // module -> key -> value
+	Make         ProfileMake            `yaml:"make,omitempty"`
+	Output       string                 `yaml:"output,omitempty"`
+	Toolchain    ProfileToolchain       `yaml:"toolchain,omitempty"`
+	Reproducible *bool                  `yaml:"reproducible,omitempty"`
+	Verbose      *bool                  `yaml:"verbose,omitempty"`
+	Incompatible string                 `yaml:"incompatible,omitempty"` // skip or fail
+	Residuals    string                 `yaml:"residuals,omitempty"`    // warn, fail or off
+	Seed         string                 `yaml:"seed,omitempty"`         // seed for generated names
+	Engagement   string                 `yaml:"engagement,omitempty"`   // engagement ID embedded in the binaries
+	Operator     string                 `yaml:"operator,omitempty"`
+}
+
+// ProfileModule selects a module. It is written either as the module name
+// or as a mapping with the name and its parameters.
+type ProfileModule struct {
+	Name   string      `yaml:"name"`
+	Params ParamValues `yaml:"params,omitempty"`
+}
+
+// ProfileMake lists the make targets to build and the overrides passed to
+// make, see BuildVars
+type ProfileMake struct {
+	Targets []string  `yaml:"targets,omitempty"`
+	Vars    BuildVars `yaml:",inline"`
+}
+
+// ProfileToolchain pins tool versions and selects the toolchain cache
+type ProfileToolchain struct {
+	Dir             string `yaml:"dir,omitempty"`
+	Strict          *bool  `yaml:"strict,omitempty"`
+	Go              string `yaml:"go,omitempty"`
+	Protoc          string `yaml:"protoc,omitempty"`
+	ProtocGenGo     string `yaml:"protoc-gen-go,omitempty"`
+	ProtocGenGoGrpc string `yaml:"protoc-gen-go-grpc,omitempty"`
+}
+
+// UnmarshalYAML accepts a bare module name as well as a mapping
+func (m *ProfileModule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		m.Name = node.Value
+		return nil
+	}
+	type plain ProfileModule
+	return node.Decode((*plain)(m))
+}
+
+// LoadProfile reads a profile and the profiles it extends
+func LoadProfile(path string) (*Profile, error) {
+	return loadProfile(path, make(map[string]bool))
+}
+
+func loadProfile(path string, seen map[string]bool) (*Profile, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if seen[abs] {
+		return nil, fmt.Errorf("profile %s extends itself", path)
+	}
+	seen[abs] = true
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+	profile := &Profile{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(profile); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse profile %s: %w", path, err)
+	}
+
+	// Fold per-module parameters into Params so overlays can override them
+	// without repeating the module list
+	for _, m := range profile.Modules {
+		if m.Name == "" {
+			return nil, fmt.Errorf("profile %s: module without a name", path)
+		}
+		for key, value := range m.Params {
+			profile.setParam(m.Name, key, value)
+		}
+	}
+
+	if profile.Extends == "" {
+		return profile, nil
+	}
+	basePath := profile.Extends
+	if !filepath.IsAbs(basePath) {
+		basePath = filepath.Join(filepath.Dir(abs), basePath)
+	}
+	base, err := loadProfile(basePath, seen)
+	if err != nil {
+		return nil, err
+	}
+	return base.overlay(profile), nil
+}
+
+func (p *Profile) setParam(module, key, value string) {
+	if p.Params == nil {
+		p.Params = make(map[string]ParamValues)
+	}
+	if p.Params[module] == nil {
+		p.Params[module] = make(ParamValues)
+	}
+	p.Params[module][key] = value
+}
+
+// overlay returns p with every value set in top taking precedence. Lists
+// (modules, make targets) are replaced, parameters are merged per key.
+func (p *Profile) overlay(top *Profile) *Profile {
+	merged := *p
+	merged.Extends = top.Extends
+	merged.Params = nil
+	for _, layer := range []*Profile{p, top} {
+		for module, params := range layer.Params {
+			for key, value := range params {
+				merged.setParam(module, key, value)
+			}
+		}
+	}
+
+	setString(&merged.Source, top.Source)
+	setString(&merged.Ref, top.Ref)
+	setString(&merged.Output, top.Output)
+	setString(&merged.Incompatible, top.Incompatible)
+	setString(&merged.Residuals, top.Residuals)
+	setString(&merged.Seed, top.Seed)
+	setString(&merged.Engagement, top.Engagement)
+	setString(&merged.Operator, top.Operator)
+	if top.Modules != nil {
+		merged.Modules = top.Modules
+	}
+	if top.Make.Targets != nil {
+		merged.Make.Targets = top.Make.Targets
+	}
+	merged.Make.Vars.X = mergeMaps(p.Make.Vars.X, top.Make.Vars.X)
+	merged.Make.Vars.Env = mergeMaps(p.Make.Vars.Env, top.Make.Vars.Env)
+	if top.Make.Vars.Tags != nil {
+		merged.Make.Vars.Tags = top.Make.Vars.Tags
+	}
+	setString(&merged.Make.Vars.GoFlags, top.Make.Vars.GoFlags)
+	if top.Reproducible != nil {
+		merged.Reproducible = top.Reproducible
+	}
+	if top.Verbose != nil {
+		merged.Verbose = top.Verbose
+	}
+
+	setString(&merged.Toolchain.Dir, top.Toolchain.Dir)
+	setString(&merged.Toolchain.Go, top.Toolchain.Go)
+	setString(&merged.Toolchain.Protoc, top.Toolchain.Protoc)
+	setString(&merged.Toolchain.ProtocGenGo, top.Toolchain.ProtocGenGo)
+	setString(&merged.Toolchain.ProtocGenGoGrpc, top.Toolchain.ProtocGenGoGrpc)
+	if top.Toolchain.Strict != nil {
+		merged.Toolchain.Strict = top.Toolchain.Strict
+	}
+	return &merged
+}
+
+func setString(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+// mergeMaps returns the entries of base and top, top taking precedence
+func mergeMaps(base, top map[string]string) map[string]string {
+	if len(base) == 0 && len(top) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(top))
+	for _, m := range []map[string]string{base, top} {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// ModuleNames returns the names of the selected modules in order
+func (p *Profile) ModuleNames() []string {
+	names := make([]string, len(p.Modules))
+	for i, m := range p.Modules {
+		names[i] = m.Name
+	}
+	return names
+}
+
+// flagValues maps the profile to the build flags it corresponds to. Only
+// values set in the profile are returned.
+func (p *Profile) flagValues() map[string]string {
+	values := make(map[string]string)
+	add := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	add("target", p.Ref)
+	add("source", p.Source)
+	add("modules", strings.Join(p.ModuleNames(), ","))
+	add("output", p.Output)
+	add("make", strings.Join(p.Make.Targets, ","))
+	add("tags", strings.Join(p.Make.Vars.Tags, ","))
+	add("goflags", p.Make.Vars.GoFlags)
+	add("toolchains", p.Toolchain.Dir)
+	add("incompatible", p.Incompatible)
+	add("residuals", p.Residuals)
+	add("seed", p.Seed)
+	add("engagement", p.Engagement)
+	add("operator", p.Operator)
+	if p.Toolchain.Strict != nil {
+		add("strict-toolchain", fmt.Sprint(*p.Toolchain.Strict))
+	}
+	if p.Reproducible != nil {
+		add("reproducible", fmt.Sprint(*p.Reproducible))
+	}
+	if p.Verbose != nil {
+		add("verbose", fmt.Sprint(*p.Verbose))
+	}
+	return values
+}
+
+// toolchain returns the tool versions pinned by the profile
+func (p *Profile) toolchain() Toolchain {
+	return Toolchain{
+		Go:              p.Toolchain.Go,
+		Protoc:          p.Toolchain.Protoc,
+		ProtocGenGo:     p.Toolchain.ProtocGenGo,
+		ProtocGenGoGrpc: p.Toolchain.ProtocGenGoGrpc,
+	}
+}
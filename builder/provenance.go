@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ProvenanceFile is the Go file the provenance record is written to in
+// every package main listed in provenancePackages
+const ProvenanceFile = "cloak_provenance.go"
+
+// provenancePrefix starts every embedded record, see ProvenanceRecord
+const provenancePrefix = "cloak-provenance:v1:"
+
+// Record signature algorithms. Records are signed with an HMAC when a
+// provenance key is given, and only checksummed otherwise.
+const (
+	SignatureHMAC   = "hmac-sha256"
+	SignatureSHA256 = "sha256"
+)
+
+// provenancePackages are the directories of the binaries built by the
+// Makefile, relative to the source tree
+var provenancePackages = []string{"server", "client"}
+
+// provenancePattern matches an encoded record: algorithm, base64url JSON
+// payload and hex signature
+var provenancePattern = regexp.MustCompile(regexp.QuoteMeta(provenancePrefix) + `(` + SignatureHMAC + `|` + SignatureSHA256 + `):([A-Za-z0-9_-]+)\.([0-9a-f]{64})`)
+
+// provenanceTemplate is the generated Go file. KeepAlive stops the linker
+// from dropping the otherwise unused string.
+const provenanceTemplate = `// Code generated by cloak. DO NOT EDIT.
+
+package main
+
+import "runtime"
+
+var cloakProvenance = %q
+
+func init() {
+	runtime.KeepAlive(cloakProvenance)
+}
+`
+
+// Provenance identifies the engagement and run a binary was built for
+type Provenance struct {
+	Engagement string    `json:"engagement"`
+	Operator   string    `json:"operator,omitempty"`
+	Run        string    `json:"run"`
+	Commit     string    `json:"commit"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// ProvenanceRecord is a provenance record as embedded in the binaries
+type ProvenanceRecord struct {
+	Provenance
+	Signature string `json:"signature"` // SignatureHMAC or SignatureSHA256
+	Record    string `json:"record"`    // encoded form, embedded verbatim
+}
+
+// signProvenance encodes p, signing it with key if key is not empty
+func signProvenance(p Provenance, key []byte) (*ProvenanceRecord, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode provenance: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	signature := SignatureSHA256
+	if len(key) > 0 {
+		signature = SignatureHMAC
+	}
+	return &ProvenanceRecord{
+		Provenance: p,
+		Signature:  signature,
+		Record:     provenancePrefix + signature + ":" + encoded + "." + provenanceMAC(signature, encoded, key),
+	}, nil
+}
+
+// provenanceMAC returns the hex signature of an encoded payload
+func provenanceMAC(signature, encoded string, key []byte) string {
+	if signature == SignatureHMAC {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(encoded))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256([]byte(encoded))
+	return hex.EncodeToString(sum[:])
+}
+
+// Errors returned by verifyProvenance
+var (
+	ErrProvenanceSignature = errors.New("provenance signature does not match")
+	ErrProvenanceNoKey     = errors.New("provenance record is signed, but no key was given")
+)
+
+// parseProvenance decodes an encoded record. Signature checking is left to
+// verifyProvenance.
+func parseProvenance(record string) (*ProvenanceRecord, error) {
+	match := provenancePattern.FindStringSubmatch(record)
+	if match == nil || match[0] != record {
+		return nil, errors.New("malformed provenance record")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(match[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed provenance record: %w", err)
+	}
+	parsed := &ProvenanceRecord{Signature: match[1], Record: record}
+	if err := json.Unmarshal(payload, &parsed.Provenance); err != nil {
+		return nil, fmt.Errorf("malformed provenance record: %w", err)
+	}
+	return parsed, nil
+}
+
+// verifyProvenance checks the signature of a record. HMAC signed records
+// need the key they were signed with.
+func verifyProvenance(r *ProvenanceRecord, key []byte) error {
+	if r.Signature == SignatureHMAC && len(key) == 0 {
+		return ErrProvenanceNoKey
+	}
+	match := provenancePattern.FindStringSubmatch(r.Record)
+	if match == nil {
+		return errors.New("malformed provenance record")
+	}
+	want := provenanceMAC(r.Signature, match[2], key)
+	if !hmac.Equal([]byte(want), []byte(match[3])) {
+		return ErrProvenanceSignature
+	}
+	return nil
+}
+
+// findProvenance returns the distinct records embedded in data
+func findProvenance(data []byte) []*ProvenanceRecord {
+	var records []*ProvenanceRecord
+	seen := make(map[string]bool)
+	for _, match := range provenancePattern.FindAll(data, -1) {
+		if seen[string(match)] {
+			continue
+		}
+		seen[string(match)] = true
+		if record, err := parseProvenance(string(match)); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// readProvenanceKey reads the key used to sign provenance records. Trailing
+// newlines are not part of the key.
+func readProvenanceKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provenance key: %w", err)
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("provenance key %s is empty", path)
+	}
+	return key, nil
+}
+
+// embedProvenance writes the run's provenance record into the binaries'
+// main packages. A run without an engagement embeds nothing. Rebuilds of a
+// run (verify repro) embed the original record unchanged.
+func (b *Builder) embedProvenance() error {
+	record := b.config.Provenance
+	if record == nil {
+		if b.config.Engagement == "" {
+			return nil
+		}
+		var err error
+		record, err = signProvenance(Provenance{
+			Engagement: b.config.Engagement,
+			Operator:   b.config.Operator,
+			Run:        b.meta.ID,
+			Commit:     b.meta.Commit,
+			Timestamp:  time.Now().UTC().Truncate(time.Second),
+		}, b.config.ProvenanceKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	srcDir := filepath.Join(b.config.RunDir, "sliver")
+	source := []byte(fmt.Sprintf(provenanceTemplate, record.Record))
+	var written []string
+	for _, pkg := range provenancePackages {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		ok, err := isMainPackage(dir)
+		if err != nil {
+			return fmt.Errorf("failed to inspect package %s: %w", pkg, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, ProvenanceFile), source, 0644); err != nil {
+			return fmt.Errorf("failed to write provenance: %w", err)
+		}
+		written = append(written, pkg)
+	}
+	if len(written) == 0 {
+		return fmt.Errorf("no main package found for the provenance record (looked in %s)", strings.Join(provenancePackages, ", "))
+	}
+
+	b.meta.Provenance = record
+	if err := writeRunMeta(b.meta); err != nil {
+		return err
+	}
+	log.Printf("Embedded provenance for engagement %s in %s (%s)", record.Engagement, strings.Join(written, ", "), record.Signature)
+	return nil
+}
+
+// isMainPackage reports whether dir holds the non-test Go files of a
+// package main
+func isMainPackage(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err != nil {
+			return false, err
+		}
+		return file.Name.Name == "main", nil
+	}
+	return false, nil
+}
+
+// Identification is the result of 'cloak identify' for one binary
+type Identification struct {
+	File       string            `json:"file"`
+	SHA256     string            `json:"sha256"`
+	Provenance *ProvenanceRecord `json:"provenance,omitempty"`
+	Verified   bool              `json:"verified"`
+	Error      string            `json:"error,omitempty"`
+	Artifact   string            `json:"artifact,omitempty"` // matching artifact of the recorded run
+}
+
+// identify extracts and verifies the provenance record of a binary. If
+// outputDir holds the recorded run, the binary is matched against the
+// run's artifacts.
+func identify(path string, key []byte, outputDir string) (*Identification, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	id := &Identification{File: path, SHA256: hex.EncodeToString(sum[:])}
+
+	records := findProvenance(data)
+	switch len(records) {
+	case 0:
+		id.Error = "no provenance record found"
+		return id, nil
+	case 1:
+	default:
+		id.Error = fmt.Sprintf("%d different provenance records found", len(records))
+		return id, nil
+	}
+	id.Provenance = records[0]
+	if err := verifyProvenance(id.Provenance, key); err != nil {
+		id.Error = err.Error()
+		return id, nil
+	}
+	id.Verified = true
+
+	run, err := findRun(outputDir, id.Provenance.Run)
+	if err != nil {
+		return id, nil
+	}
+	// Identical artifacts, e.g. a copied client, match by name first
+	for _, artifact := range run.Artifacts {
+		if artifact.SHA256 == id.SHA256 && (id.Artifact == "" || artifact.Name == filepath.Base(path)) {
+			id.Artifact = artifact.Name
+		}
+	}
+	return id, nil
+}
+
+// identifyCommand implements 'cloak identify <binary>...'
+func identifyCommand(args []string) error {
+	fs := newFlagSet("identify", "[flags] <binary>...", "Extract and verify the provenance record embedded by 'cloak build -engagement'.")
+	outputDir := outputFlag(fs)
+	keyFile := fs.String("key", os.Getenv("CLOAK_PROVENANCE_KEY"), "File holding the key the records were signed with (env CLOAK_PROVENANCE_KEY)")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: cloak identify [flags] <binary>...")
+	}
+	key, err := readProvenanceKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	var results []*Identification
+	failed := 0
+	for _, path := range positional {
+		id, err := identify(path, key, *outputDir)
+		if err != nil {
+			return fmt.Errorf("failed to identify %s: %w", path, err)
+		}
+		if !id.Verified {
+			failed++
+		}
+		results = append(results, id)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		for i, id := range results {
+			if i > 0 {
+				fmt.Println()
+			}
+			writeIdentification(id)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d binaries could not be identified", failed, len(results))
+	}
+	return nil
+}
+
+// writeIdentification prints an identification for humans
+func writeIdentification(id *Identification) {
+	fmt.Printf("%s (sha256 %s)\n", id.File, id.SHA256)
+	if p := id.Provenance; p != nil {
+		fmt.Printf("  engagement: %s\n", p.Engagement)
+		if p.Operator != "" {
+			fmt.Printf("  operator:   %s\n", p.Operator)
+		}
+		fmt.Printf("  run:        %s\n", p.Run)
+		fmt.Printf("  commit:     %s\n", p.Commit)
+		fmt.Printf("  built:      %s\n", p.Timestamp.Format(time.RFC3339))
+	}
+	switch {
+	case id.Error != "":
+		fmt.Printf("  error:      %s\n", id.Error)
+	case id.Provenance.Signature == SignatureHMAC:
+		fmt.Printf("  signature:  valid (%s)\n", SignatureHMAC)
+	default:
+		fmt.Printf("  signature:  unsigned, checksum valid\n")
+	}
+	if id.Artifact != "" {
+		fmt.Printf("  artifact:   %s of run %s\n", id.Artifact, id.Provenance.Run)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProvenanceRecord(t *testing.T) {
+	p := Provenance{
+		Engagement: "ACME-2026-07",
+		Operator:   "jdoe",
+		Run:        "run_1.5_20260101_120000",
+		Commit:     "e7d99b4a47a3931ba0c1c0ab11ab8018b43fb233",
+		Timestamp:  time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	key := []byte("secret")
+
+	signed, err := signProvenance(p, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := signProvenance(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are found between arbitrary binary data, once each
+	data := []byte("\x00\x01" + signed.Record + "0123abcd\x00" + signed.Record + unsigned.Record + "\xff")
+	records := findProvenance(data)
+	if len(records) != 2 {
+		t.Fatalf("found %d records, want 2", len(records))
+	}
+	if records[0].Provenance != p || records[0].Signature != SignatureHMAC || records[0].Record != signed.Record {
+		t.Errorf("parsed %+v, want %+v", records[0], signed)
+	}
+	if records[1].Signature != SignatureSHA256 {
+		t.Errorf("unsigned record has signature %s", records[1].Signature)
+	}
+
+	if err := verifyProvenance(records[0], key); err != nil {
+		t.Errorf("signed record: %v", err)
+	}
+	if err := verifyProvenance(records[0], nil); !errors.Is(err, ErrProvenanceNoKey) {
+		t.Errorf("signed record without key: got %v", err)
+	}
+	if err := verifyProvenance(records[0], []byte("wrong")); !errors.Is(err, ErrProvenanceSignature) {
+		t.Errorf("signed record with wrong key: got %v", err)
+	}
+	if err := verifyProvenance(records[1], nil); err != nil {
+		t.Errorf("unsigned record: %v", err)
+	}
+
+	// Changing the payload breaks the checksum
+	p.Engagement = "OTHER"
+	forged, err := signProvenance(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := *records[1]
+	tampered.Record = forged.Record[:len(forged.Record)-64] + unsigned.Record[len(unsigned.Record)-64:]
+	if err := verifyProvenance(&tampered, nil); !errors.Is(err, ErrProvenanceSignature) {
+		t.Errorf("tampered record: got %v", err)
+	}
+}
+
+func TestIsMainPackage(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("server/main_test.go", "package main_test\n")
+	write("server/main.go", "// Server\npackage main\n")
+	write("client/console/console.go", "package console\n")
+	write("client/console.go", "package client\n")
+
+	for pkg, want := range map[string]bool{"server": true, "client": false, "client/console": false, "missing": false} {
+		got, err := isMainPackage(filepath.Join(dir, pkg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("isMainPackage(%s) = %v, want %v", pkg, got, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// makeOverlay is passed to make after the project Makefile. It adds a target
+// that prints the expanded value of any Makefile variable.
+const makeOverlay = `cloak-print-%:
+	$(info $($*))
+	@:
+`
+
+// sourceDateEpoch returns the committer timestamp of the checked out commit,
+// which is used as the build time for reproducible builds
+func (b *Builder) sourceDateEpoch() (string, error) {
+	cmd := exec.Command("git", "log", "-1", "--format=%ct")
+	cmd.Dir = filepath.Join(b.config.RunDir, "sliver")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit time: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// goVersion returns the output of 'go version' in the given environment
+func goVersion(env []string) (string, error) {
+	cmd := exec.Command("go", "version")
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get go version: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// makeVariable returns the expanded value of a variable defined by the
+// project Makefile, with vars given as command line overrides
+func (b *Builder) makeVariable(makeDir, name string, env, vars []string) (string, error) {
+	overlay := filepath.Join(b.config.RunDir, "cloak.mk")
+	if err := os.WriteFile(overlay, []byte(makeOverlay), 0644); err != nil {
+		return "", fmt.Errorf("failed to write make overlay: %w", err)
+	}
+
+	args := []string{"--no-print-directory", "-s", "-f", "Makefile", "-f", overlay}
+	cmd := exec.Command("make", append(append(args, vars...), "cloak-print-"+name)...)
+	cmd.Dir = makeDir
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read make variable %s: %w", name, err)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// appendLDFlags returns a make variable assignment that replaces the
+// Makefile's LDFLAGS (of the form -ldflags "...") with one that has extra
+// appended to the quoted linker flags. LDFLAGS is expanded once, with vars
+// applied, so any $(shell ...) in it is frozen at this point.
+func (b *Builder) appendLDFlags(makeDir string, env, vars []string, extra string) (string, error) {
+	value, err := b.makeVariable(makeDir, "LDFLAGS", env, vars)
+	if err != nil {
+		return "", err
+	}
+
+	words, err := splitShellWords(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse LDFLAGS: %w", err)
+	}
+	var ldflags string
+	switch {
+	case len(words) == 0:
+	case len(words) == 2 && words[0] == "-ldflags":
+		ldflags = words[1]
+	default:
+		return "", fmt.Errorf("unsupported LDFLAGS format: %s", value)
+	}
+	ldflags = strings.TrimSpace(ldflags + " " + extra)
+
+	// Escape for the shell's double quotes, then for make's own expansion
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$$`).Replace(ldflags)
+	return `LDFLAGS=-ldflags "` + quoted + `"`, nil
+}
+
+// splitShellWords splits a string into words the way a POSIX shell would,
+// honouring single quotes, double quotes and backslash escapes
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	for i := 0; i < len(s); i++ {
+		c := rune(s[i])
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(s) && strings.ContainsRune("\\\"$`", rune(s[i+1])):
+				i++
+				word.WriteByte(s[i])
+			default:
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// setupReproducible pins everything that would otherwise make two builds of
+// the same inputs differ: timestamps, paths and the Go toolchain. It returns
+// the build environment and make variable overrides.
+func (b *Builder) setupReproducible(env []string) ([]string, []string, error) {
+	epoch, err := b.sourceDateEpoch()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	goflags := strings.TrimSpace(getEnv(env, "GOFLAGS") + " -trimpath -buildvcs=false")
+	for _, kv := range [][2]string{
+		{"SOURCE_DATE_EPOCH", epoch},
+		{"GOFLAGS", goflags},
+		{"GOTOOLCHAIN", "local"},
+		{"TZ", "UTC"},
+		{"LC_ALL", "C"},
+	} {
+		env = setEnv(env, kv[0], kv[1])
+	}
+
+	version, err := goVersion(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// runMake appends -buildid= to the linker flags
+	vars := []string{"COMPILED_AT=" + epoch}
+
+	b.meta.Reproducible = &ReproInfo{
+		SourceDateEpoch: epoch,
+		GoVersion:       version,
+		GoFlags:         goflags,
+	}
+	if b.verbose {
+		log.Printf("Reproducible build: SOURCE_DATE_EPOCH=%s, %s", epoch, version)
+	}
+	return env, vars, nil
+}
+
+// verifyReproCommand implements 'cloak verify repro <run>'. It rebuilds the
+// recorded commit with the recorded modules and flags, and compares the
+// resulting artifact hashes with the original run.
+func verifyReproCommand(args []string) error {
+	fs := newFlagSet("verify repro", "[flags] <run>", "Rebuild a -reproducible run from its recorded inputs and compare artifact hashes.")
+	outputDir := outputFlag(fs)
+	verbose := fs.Bool("verbose", envBool("CLOAK_VERBOSE", false), "Show build output (env CLOAK_VERBOSE)")
+	auditLog := auditLogFlag(fs)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: cloak verify repro <run>")
+	}
+
+	original, err := findRun(*outputDir, positional[0])
+	if err != nil {
+		return err
+	}
+	if original.Status != RunStatusSuccess {
+		return fmt.Errorf("run %s did not succeed (status %s)", original.ID, original.Status)
+	}
+	if original.Reproducible == nil {
+		return fmt.Errorf("run %s was not built with -reproducible", original.ID)
+	}
+
+	config, err := NewConfig(original.Flags["target"], *outputDir)
+	if err != nil {
+		return fmt.Errorf("failed to create config: %w", err)
+	}
+	config.RepoURL = original.RepoURL
+	config.Target.Commit = original.Commit
+	config.Reproducible = true
+	config.Flags = original.Flags
+	config.ModuleParams = original.Params
+	config.Seed = original.Seed
+	if original.BuildVars != nil {
+		config.BuildVars = *original.BuildVars
+	}
+	config.Provenance = original.Provenance
+	// Rebuild with the exact tools the original resolved
+	config.Target.Toolchain = original.Toolchain
+	config.StrictToolchain, _ = strconv.ParseBool(original.Flags["strict-toolchain"])
+	config.AuditLog = auditLogPath(*auditLog, *outputDir)
+	if engagement := original.Flags["engagement"]; engagement != "" {
+		config.Engagement = engagement
+	}
+	if policy := original.Flags["incompatible"]; policy != "" {
+		config.Incompatible = policy
+	}
+	if policy := original.Flags["residuals"]; policy != "" {
+		config.Residuals = policy
+	}
+	if dir := original.Flags["toolchains"]; dir != "" {
+		config.ToolchainDir = dir
+	}
+	if targets := original.Flags["make"]; targets != "" {
+		config.MakeTargets = strings.Split(targets, ",")
+	}
+
+	log.Println("Reproducing run:", original.ID)
+	log.Println("Run directory:", config.RunDir)
+
+	modulesDir := original.Flags["modules-dir"]
+	if modulesDir == "" {
+		modulesDir = envOr("CLOAK_MODULES_DIR", DefaultModulesDir)
+	}
+	builder, err := newModuleBuilder(config, *verbose, modulesDir)
+	if err != nil {
+		return err
+	}
+	builder.reproduces = original.ID
+	if err := builder.Run(original.Modules); err != nil {
+		return err
+	}
+
+	rebuilt := builder.meta
+	if rebuilt.Reproducible.GoVersion != original.Reproducible.GoVersion {
+		return fmt.Errorf("toolchain differs: %s (original) vs %s", original.Reproducible.GoVersion, rebuilt.Reproducible.GoVersion)
+	}
+
+	diff, err := compareRuns(original, rebuilt)
+	if err != nil {
+		return err
+	}
+	if len(diff.Artifacts) > 0 {
+		diff.writeText(os.Stdout)
+		return fmt.Errorf("run %s is not reproducible", original.ID)
+	}
+
+	for _, artifact := range rebuilt.Artifacts {
+		log.Printf("Reproduced %s (sha256 %s)", artifact.Name, artifact.SHA256)
+	}
+	log.Printf("Run %s reproduced byte-for-byte", original.ID)
+	return nil
+}
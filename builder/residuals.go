@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Policies for search terms still found after the build
+const (
+	ResidualsWarn = "warn" // report and continue
+	ResidualsFail = "fail" // fail the run
+	ResidualsOff  = "off"  // do not scan
+)
+
+// ResidualsFile is the scan report in a run directory
+const ResidualsFile = "residuals.json"
+
+// Origins of a residual occurrence
+const (
+	OriginSliver = "sliver" // Sliver's own code
+	OriginVendor = "vendor" // vendored or module dependencies
+	OriginStdlib = "stdlib" // the Go standard library
+)
+
+// sliverModule is the upstream module path of Sliver
+const sliverModule = "github.com/bishopfox/sliver"
+
+// Renamer is implemented by modules that remove terms from the source tree.
+// The terms are searched for after the build to find what the module missed,
+// and the replacements it applied are recorded in run.json.
+type Renamer interface {
+	SearchTerms() []string
+	Replacements() []Replacement
+}
+
+// Replacement is a search term and what a module replaced it with
+type Replacement struct {
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+// pairReplacements converts the search and replace pairs of a module
+func pairReplacements(pairs []SearchReplacePair) []Replacement {
+	replacements := make([]Replacement, len(pairs))
+	for i, pair := range pairs {
+		replacements[i] = Replacement{Search: pair.search, Replace: pair.replace}
+	}
+	return replacements
+}
+
+// Residual is one occurrence of a search term after the build
+type Residual struct {
+	Term    string `json:"term"`
+	File    string `json:"file"` // relative to the run directory
+	Offset  int64  `json:"offset"`
+	Line    int    `json:"line,omitempty"` // source files only
+	Origin  string `json:"origin"`
+	Context string `json:"context,omitempty"` // surrounding string in binaries
+}
+
+// ResidualReport is the result of a residual scan
+type ResidualReport struct {
+	Terms     []string   `json:"terms"`
+	Residuals []Residual `json:"residuals"`
+}
+
+// ResidualSummary is the part of a residual scan recorded in run.json
+type ResidualSummary struct {
+	Terms   []string       `json:"terms"`
+	Scanned bool           `json:"scanned"`
+	Total   int            `json:"total"`
+	Origins map[string]int `json:"origins,omitempty"`
+}
+
+// Summary counts the residuals by origin
+func (r *ResidualReport) Summary() *ResidualSummary {
+	s := &ResidualSummary{Terms: r.Terms, Scanned: true, Total: len(r.Residuals)}
+	for _, residual := range r.Residuals {
+		if s.Origins == nil {
+			s.Origins = make(map[string]int)
+		}
+		s.Origins[residual.Origin]++
+	}
+	return s
+}
+
+// searchTerms returns the sorted search terms of the selected modules
+func (b *Builder) searchTerms(moduleNames []string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, name := range moduleNames {
+		r, ok := b.modules[name].(Renamer)
+		if !ok {
+			continue
+		}
+		for _, term := range r.SearchTerms() {
+			if term != "" && !seen[term] {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// checkResiduals scans the source tree and artifacts of the run for the
+// search terms of the modules that ran, and writes the report to the run
+// directory
+func (b *Builder) checkResiduals(moduleNames []string, artifacts []Artifact) error {
+	terms := b.searchTerms(moduleNames)
+	if len(terms) == 0 {
+		return nil
+	}
+	b.meta.Residuals = &ResidualSummary{Terms: terms}
+	if b.config.Residuals == ResidualsOff {
+		return nil
+	}
+
+	log.Println("Scanning for residual terms...")
+	report, err := scanResiduals(b.config.RunDir, terms, artifacts)
+	if err != nil {
+		return fmt.Errorf("residual scan failed: %w", err)
+	}
+	if err := writeResidualReport(b.config.RunDir, report); err != nil {
+		return err
+	}
+	b.meta.Residuals = report.Summary()
+	if len(report.Residuals) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%d residual occurrences of %s (%s), see %s", len(report.Residuals),
+		strings.Join(terms, ", "), formatOrigins(b.meta.Residuals.Origins), ResidualsFile)
+	if b.config.Residuals == ResidualsFail {
+		return fmt.Errorf("%s", msg)
+	}
+	log.Println("Warning:", msg)
+	return nil
+}
+
+// scanResiduals searches the source tree of a run, except its git metadata,
+// and the given artifacts for every term. A binary is scanned once, at its
+// first artifact path, even if copies of it are in the tree.
+func scanResiduals(runDir string, terms []string, artifacts []Artifact) (*ResidualReport, error) {
+	srcDir := filepath.Join(runDir, "sliver")
+	c := classifier{module: goModulePath(srcDir)}
+	report := &ResidualReport{Terms: terms, Residuals: []Residual{}}
+
+	artifactData := make([][]byte, len(artifacts))
+	scanned := make(map[[sha256.Size]byte]bool)
+	for i, artifact := range artifacts {
+		data, err := os.ReadFile(filepath.Join(runDir, artifact.Path))
+		if err != nil {
+			return nil, err
+		}
+		artifactData[i] = data
+		scanned[sha256.Sum256(data)] = false
+	}
+
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == srcDir {
+			return filepath.SkipDir // cleaned run
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(runDir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// Build output left in the tree is scanned like an artifact
+		if isBinary(data) {
+			if _, artifact := scanned[sha256.Sum256(data)]; artifact {
+				return nil
+			}
+			report.Residuals = append(report.Residuals, c.scanBinary(filepath.ToSlash(rel), data, terms)...)
+			return nil
+		}
+		origin := c.source(filepath.ToSlash(rel))
+		for _, r := range findTerms(data, terms) {
+			r.File = filepath.ToSlash(rel)
+			r.Line = 1 + bytes.Count(data[:r.Offset], []byte("\n"))
+			r.Origin = origin
+			report.Residuals = append(report.Residuals, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, artifact := range artifacts {
+		sum := sha256.Sum256(artifactData[i])
+		if scanned[sum] {
+			continue
+		}
+		scanned[sum] = true
+		report.Residuals = append(report.Residuals, c.scanBinary(artifact.Path, artifactData[i], terms)...)
+	}
+	return report, nil
+}
+
+// scanBinary finds the terms in a binary file and classifies each
+// occurrence by the string around it
+func (c classifier) scanBinary(file string, data []byte, terms []string) []Residual {
+	found := findTerms(data, terms)
+	for i := range found {
+		var at int
+		found[i].File = file
+		found[i].Context, at = stringAt(data, int(found[i].Offset), len(found[i].Term))
+		found[i].Origin = c.binary(found[i].Context, at)
+	}
+	return found
+}
+
+// isBinary reports whether data looks like a binary rather than text, by a
+// NUL byte near the start as git does
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// findTerms returns every occurrence of every term in data, by offset
+func findTerms(data []byte, terms []string) []Residual {
+	var found []Residual
+	for _, term := range terms {
+		needle := []byte(term)
+		for offset := 0; ; {
+			i := bytes.Index(data[offset:], needle)
+			if i < 0 {
+				break
+			}
+			found = append(found, Residual{Term: term, Offset: int64(offset + i)})
+			offset += i + len(needle)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Offset < found[j].Offset })
+	return found
+}
+
+// maxContext bounds the string returned by stringAt on either side of the
+// match
+const maxContext = 120
+
+// stringAt returns the run of printable characters around
+// data[offset:offset+n] and the position of the match in it
+func stringAt(data []byte, offset, n int) (string, int) {
+	printable := func(c byte) bool { return c >= 0x20 && c < 0x7f }
+	start, end := offset, offset+n
+	for start > 0 && offset-start < maxContext && printable(data[start-1]) {
+		start--
+	}
+	for end < len(data) && end-offset-n < maxContext && printable(data[end]) {
+		end++
+	}
+	return string(data[start:end]), offset - start
+}
+
+// goModulePath returns the module path in the go.mod of dir, if any
+func goModulePath(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// importPath matches a Go package or file path in a binary string
+var importPath = regexp.MustCompile(`[A-Za-z0-9_.\-~]+(/[A-Za-z0-9_.\-~@]+)+`)
+
+// classifier attributes residual occurrences to Sliver, its dependencies or
+// the standard library
+type classifier struct {
+	module string // module path of the source tree
+}
+
+// source classifies an occurrence in a source file by its path
+func (c classifier) source(path string) string {
+	if strings.Contains("/"+path+"/", "/vendor/") {
+		return OriginVendor
+	}
+	return OriginSliver
+}
+
+// binary classifies an occurrence in a binary by the import or file path
+// around it, at is the offset of the match in context. Go binaries name
+// packages and files by import path: Sliver's own module, other modules
+// (with a domain in the first element) or the standard library (without).
+// Without -trimpath, files are named by absolute path instead.
+func (c classifier) binary(context string, at int) string {
+	var path string
+	for _, span := range importPath.FindAllStringIndex(context, -1) {
+		if span[0] <= at && at < span[1] {
+			path = context[span[0]:span[1]]
+			if span[0] > 0 && context[span[0]-1] == '/' {
+				path = "/" + path
+			}
+			break
+		}
+	}
+
+	switch {
+	case path == "":
+		return OriginSliver
+	case strings.Contains(path, "/vendor/") || strings.Contains(path, "/pkg/mod/"):
+		return OriginVendor
+	case strings.Contains(path, "/go/src/") || strings.Contains(path, "golang.org/toolchain"):
+		return OriginStdlib
+	case strings.HasPrefix(path, sliverModule) || (c.module != "" && strings.HasPrefix(path, c.module)):
+		return OriginSliver
+	case strings.HasPrefix(path, "/"):
+		return OriginSliver // the source tree, built without -trimpath
+	case strings.Contains(strings.SplitN(path, "/", 2)[0], "."):
+		return OriginVendor
+	case isStdlibPath(path):
+		return OriginStdlib
+	}
+	return OriginSliver
+}
+
+// isStdlibPath reports whether path looks like a standard library package
+// or file: lower case elements without a domain, e.g. "crypto/tls"
+func isStdlibPath(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "" || strings.ToLower(elem) != elem {
+			return false
+		}
+	}
+	return true
+}
+
+// formatOrigins formats residual counts by origin, e.g. "sliver 3, vendor 1"
+func formatOrigins(origins map[string]int) string {
+	var parts []string
+	for _, origin := range []string{OriginSliver, OriginVendor, OriginStdlib} {
+		if n := origins[origin]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", origin, n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeResidualReport writes the report into the run directory
+func writeResidualReport(runDir string, report *ResidualReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileFrom(filepath.Join(runDir, ResidualsFile), bytes.NewReader(append(data, '\n')), 0644); err != nil {
+		return fmt.Errorf("failed to write residual report: %w", err)
+	}
+	return nil
+}
+
+// writeResiduals prints one line per residual occurrence followed by the
+// counts by origin
+func writeResiduals(w io.Writer, report *ResidualReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tOFFSET\tTERM\tORIGIN\tCONTEXT")
+	for _, r := range report.Residuals {
+		location := fmt.Sprintf("%d", r.Offset)
+		if r.Line > 0 {
+			location = fmt.Sprintf("%d (line %d)", r.Offset, r.Line)
+		}
+		context := r.Context
+		if len(context) > 60 {
+			context = context[:57] + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.File, location, r.Term, r.Origin, context)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d residual occurrences", len(report.Residuals))
+	if origins := report.Summary().Origins; len(origins) > 0 {
+		fmt.Fprintf(w, ": %s", formatOrigins(origins))
+	}
+	fmt.Fprintln(w)
+}
+
+// verifyResidualsCommand implements 'cloak verify residuals'
+func verifyResidualsCommand(args []string) error {
+	fs := newFlagSet("verify residuals", "[flags] <run>", "Scan the source tree and artifacts of a run for the search terms of its modules.")
+	outputDir := outputFlag(fs)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	var extra listFlag
+	fs.Var(&extra, "term", "Also search for this term (repeatable)")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: cloak verify residuals <run>")
+	}
+
+	run, err := findRun(*outputDir, positional[0])
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	var terms []string
+	if run.Residuals != nil {
+		terms = append(terms, run.Residuals.Terms...)
+	}
+	for _, term := range append(terms, extra...) {
+		seen[term] = term != ""
+	}
+	terms = terms[:0]
+	for term, ok := range seen {
+		if ok {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return fmt.Errorf("run %s has no recorded search terms, use -term", run.ID)
+	}
+	sort.Strings(terms)
+
+	report, err := scanResiduals(run.Dir(), terms, run.Artifacts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		writeResiduals(os.Stdout, report)
+	}
+	if len(report.Residuals) > 0 {
+		return fmt.Errorf("run %s has %d residual occurrences", run.ID, len(report.Residuals))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloak/pkg/moduletest"
+)
+
+func TestScanResiduals(t *testing.T) {
+	m := NewBrandingModule()
+	values, err := resolveParams(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Configure(values); err != nil {
+		t.Fatal(err)
+	}
+	runDir := moduletest.Setup(t, fixture)
+	if err := m.Run(&Config{RunDir: runDir}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fake binary with strings from Sliver, a dependency and the stdlib
+	binary := "\x00\x01github.com/bishopfox/sliver/client/console.go\x00" +
+		"\x02/root/go/pkg/mod/github.com/x/sliverlib@v1.0.0/lib.go\x00" +
+		"\x03crypto/sliver/cipher.go\x00" +
+		"\x04starting Sliver\x00"
+	if err := os.MkdirAll(filepath.Join(runDir, ArtifactsDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, ArtifactsDir, "gunner-server"), []byte(binary), 0755); err != nil {
+		t.Fatal(err)
+	}
+	artifacts := []Artifact{{Name: "gunner-server", Path: ArtifactsDir + "/gunner-server"}}
+	// The same binary left in the tree by make is only counted once
+	if err := os.WriteFile(filepath.Join(runDir, moduletest.SourceDir, "gunner-server"), []byte(binary), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := scanResiduals(runDir, m.SearchTerms(), artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type key struct{ file, term, origin string }
+	got := make(map[key]Residual)
+	for _, r := range report.Residuals {
+		got[key{r.File, r.Term, r.Origin}] = r
+	}
+	want := []key{
+		{"sliver/docs/sliver.md", "Sliver", OriginSliver}, // ignored directory
+		{"artifacts/gunner-server", "sliver", OriginSliver},
+		{"artifacts/gunner-server", "bishopfox", OriginSliver},
+		{"artifacts/gunner-server", "sliver", OriginVendor},
+		{"artifacts/gunner-server", "sliver", OriginStdlib},
+		{"artifacts/gunner-server", "Sliver", OriginSliver},
+	}
+	for _, k := range want {
+		if _, ok := got[k]; !ok {
+			t.Errorf("missing residual %+v", k)
+		}
+	}
+	if len(report.Residuals) != 6 {
+		t.Errorf("expected 6 residuals, got %+v", report.Residuals)
+	}
+
+	if r := got[key{"sliver/docs/sliver.md", "Sliver", OriginSliver}]; r.Offset != 0 || r.Line != 1 {
+		t.Errorf("docs residual at offset %d line %d, want 0 and 1", r.Offset, r.Line)
+	}
+	if r := got[key{"artifacts/gunner-server", "bishopfox", OriginSliver}]; r.Offset != 13 {
+		t.Errorf("binary residual at offset %d, want 13", r.Offset)
+	}
+}
+
+func TestClassifyBinary(t *testing.T) {
+	c := classifier{module: "github.com/knightbruce/gunner"}
+	for context, want := range map[string]string{
+		"github.com/knightbruce/gunner/sliver.go":                   OriginSliver,
+		"github.com/bishopfox/sliver/protobuf":                      OriginSliver,
+		"github.com/other/sliver/x.go":                              OriginVendor,
+		"github.com/knightbruce/gunner/vendor/a/sliver.go":          OriginVendor,
+		"/usr/local/go/src/net/sliver.go":                           OriginStdlib,
+		"net/http/sliver.go":                                        OriginStdlib,
+		"/tmp/output/run_1.6/sliver/server/main.go":                 OriginSliver,
+		"connected to sliver server":                                OriginSliver,
+		"golang.org/x/sys/unix.sliver":                              OriginVendor,
+		"golang.org/toolchain@v0.0.1-go1.22.linux-amd64/src/sliver": OriginStdlib,
+	} {
+		at := strings.Index(context, "sliver")
+		if got := c.binary(context, at); got != want {
+			t.Errorf("%q is %s, want %s", context, got, want)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RunMetaFile is the name of the metadata file written to every run directory
+const RunMetaFile = "run.json"
+
+// ArtifactsDir is the directory inside a run directory that build outputs are
+// copied to
+const ArtifactsDir = "artifacts"
+
+// Run status values recorded in run.json
+const (
+	RunStatusRunning = "running"
+	RunStatusSuccess = "success"
+	RunStatusFailed  = "failed"
+)
+
+// Artifact describes a file produced by the build
+type Artifact struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"` // relative to the run directory
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// RunMeta is the record of a single cloak run, stored as run.json
+type RunMeta struct {
+	ID        string    `json:"id"`
+	Target    string    `json:"target"`
+	GitRef    string    `json:"git_ref"`
+	RepoURL   string    `json:"repo_url"`
+	Commit    string    `json:"commit,omitempty"`
+	Version   string    `json:"version,omitempty"` // nearest release tag of Commit
+	Modules   []string  `json:"modules"`
+	Toolchain Toolchain `json:"toolchain"`
+
+	Requirements *ToolchainRequirements `json:"requirements,omitempty"`
+	Flags        map[string]string      `json:"flags,omitempty"`
+	Params       map[string]ParamValues `json:"params,omitempty"` // module parameters
+	Seed         int64                  `json:"seed"`             // seed for generated names
+	StartedAt    time.Time              `json:"started_at"`
+	FinishedAt   *time.Time             `json:"finished_at,omitempty"`
+	Status       string                 `json:"status"`
+	Error        string                 `json:"error,omitempty"`
+	Changes      []ModuleChange         `json:"changes,omitempty"`
+	Hooks        []string               `json:"hooks,omitempty"`       // stage:module, in the order they ran
+	RolledBack   string                 `json:"rolled_back,omitempty"` // failed module whose changes were reverted
+	Skipped      []SkippedModule        `json:"skipped,omitempty"`     // incompatible modules left out
+	Artifacts    []Artifact             `json:"artifacts,omitempty"`
+	Residuals    *ResidualSummary       `json:"residuals,omitempty"`  // search terms left after the build
+	Provenance   *ProvenanceRecord      `json:"provenance,omitempty"` // record embedded in the binaries
+
+	Renames map[string][]Replacement `json:"renames,omitempty"` // module -> replacements applied
+
+	BuildVars *BuildVars `json:"build_vars,omitempty"` // make overrides from the profile and flags
+	Make      []string   `json:"make,omitempty"`       // make command lines, with the environment cloak set
+
+	Smoke *SmokeReport `json:"smoke,omitempty"` // smoke tests of the built binaries
+
+	Reproducible   *ReproInfo `json:"reproducible,omitempty"`
+	ReproducedFrom string     `json:"reproduced_from,omitempty"` // ID of the run this one rebuilt
+
+	dir string // run directory the record was loaded from
+}
+
+// ReproInfo records the pinned build inputs of a reproducible run
+type ReproInfo struct {
+	SourceDateEpoch string `json:"source_date_epoch"`
+	GoVersion       string `json:"go_version"`
+	GoFlags         string `json:"goflags"`
+}
+
+// Dir returns the run directory the record belongs to
+func (m *RunMeta) Dir() string {
+	return m.dir
+}
+
+// Duration returns the wall time of the run, or the time elapsed so far
+func (m *RunMeta) Duration() time.Duration {
+	if m.FinishedAt == nil {
+		return time.Since(m.StartedAt)
+	}
+	return m.FinishedAt.Sub(m.StartedAt)
+}
+
+// writeRunMeta atomically writes the run record into its run directory
+func writeRunMeta(meta *RunMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run metadata: %w", err)
+	}
+
+	path := filepath.Join(meta.dir, RunMetaFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run metadata: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write run metadata: %w", err)
+	}
+	return nil
+}
+
+// readRunMeta loads run.json from the given run directory
+func readRunMeta(runDir string) (*RunMeta, error) {
+	data, err := os.ReadFile(filepath.Join(runDir, RunMetaFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta RunMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(runDir, RunMetaFile), err)
+	}
+	meta.dir = runDir
+	return &meta, nil
+}
+
+// listRuns returns the runs found in outputDir, oldest first. Run directories
+// created before run.json existed are reported with only their ID and start
+// time (taken from the directory modification time), as are runs whose
+// run.json cannot be read.
+func listRuns(outputDir string) ([]*RunMeta, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output directory: %w", err)
+	}
+
+	var runs []*RunMeta
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "run_") {
+			continue
+		}
+		runDir := filepath.Join(outputDir, entry.Name())
+
+		meta, err := readRunMeta(runDir)
+		if err != nil {
+			// Missing, or corrupt from an interrupted write
+			if !os.IsNotExist(err) {
+				log.Printf("Warning: run %s: %v", entry.Name(), err)
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			meta = &RunMeta{ID: entry.Name(), StartedAt: info.ModTime(), Status: "unknown", dir: runDir}
+		}
+		runs = append(runs, meta)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.Before(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+// findRun resolves a run by ID or by path to its run directory
+func findRun(outputDir, ref string) (*RunMeta, error) {
+	runDir := ref
+	if !strings.ContainsRune(ref, os.PathSeparator) {
+		runDir = filepath.Join(outputDir, ref)
+	}
+
+	meta, err := readRunMeta(runDir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("run %s not found (no %s in %s)", ref, RunMetaFile, runDir)
+	}
+	return meta, err
+}
+
+// hashFile returns the size and hex encoded SHA-256 digest of a file
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// snapshotFiles returns the names and modification times of the regular
+// files directly inside dir
+func snapshotFiles(dir string) (map[string]time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]time.Time)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = info.ModTime()
+	}
+	return files, nil
+}
+
+// collectArtifacts copies the executables make left in the top level of the
+// source tree that were not present (or were older) before the build into
+// the run's artifacts directory and hashes them
+func (b *Builder) collectArtifacts(before map[string]time.Time) ([]Artifact, error) {
+	makeDir := filepath.Join(b.config.RunDir, "sliver")
+	after, err := snapshotFiles(makeDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list build output: %w", err)
+	}
+
+	var names []string
+	for name, modTime := range after {
+		if prev, existed := before[name]; existed && !modTime.After(prev) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var artifacts []Artifact
+	for _, name := range names {
+		path := filepath.Join(makeDir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		// Keep a copy outside the source tree so 'cloak clean' can remove it
+		rel := filepath.Join(ArtifactsDir, name)
+		src, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = writeFileFrom(filepath.Join(b.config.RunDir, rel), src, info.Mode().Perm())
+		src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy artifact %s: %w", name, err)
+		}
+
+		size, sum, err := hashFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash artifact %s: %w", name, err)
+		}
+		artifacts = append(artifacts, Artifact{
+			Name:   name,
+			Path:   rel,
+			Size:   size,
+			SHA256: sum,
+		})
+	}
+	return artifacts, nil
+}
+
+// parseAge parses a duration that may additionally use a "d" (day) suffix,
+// e.g. "7d" or "36h"
+func parseAge(s string) (time.Duration, error) {
+	var age time.Duration
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		age = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		if age, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("invalid age %q, expected a positive duration", s)
+	}
+	return age, nil
+}
+
+// runsCommand implements 'cloak runs list|show|prune'
+func runsCommand(args []string) error {
+	usage := "usage: cloak runs list|show <run>|prune [-keep N] [-older-than 7d] [-force]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if isHelpFlag(args[0]) {
+		fmt.Fprintf(os.Stderr, "usage: cloak runs <command> [flags]\n\ncommands:\n  list   list the runs in the output directory\n  show   print the record of a run\n  prune  remove old runs, keeping the most recent or newer ones\n")
+		return nil
+	}
+
+	fs := newFlagSet("runs "+args[0], "", "Manage run directories.")
+	outputDir := outputFlag(fs)
+
+	switch args[0] {
+	case "list":
+		if _, err := parseFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		runs, err := listRuns(*outputDir)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tTARGET\tCOMMIT\tMODULES\tSTARTED\tDURATION")
+		for _, run := range runs {
+			commit := run.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			duration := "-"
+			if run.FinishedAt != nil || run.Status == RunStatusRunning {
+				duration = run.Duration().Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				run.ID, run.Status, run.Target, commit, strings.Join(run.Modules, ","),
+				run.StartedAt.Format("2006-01-02 15:04:05"), duration)
+		}
+		return w.Flush()
+
+	case "show":
+		positional, err := parseFlags(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return fmt.Errorf("usage: cloak runs show <run>")
+		}
+		run, err := findRun(*outputDir, positional[0])
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(run, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+
+	case "prune":
+		keep := fs.Int("keep", -1, "Keep the N most recent runs")
+		olderThan := fs.String("older-than", "", "Only remove runs older than this age (e.g. 7d, 12h)")
+		dryRun := fs.Bool("dry-run", false, "Print the runs that would be removed")
+		force := fs.Bool("force", false, "Also remove runs that are still running")
+		if _, err := parseFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		if *keep < 0 && *olderThan == "" {
+			return fmt.Errorf("prune requires -keep and/or -older-than")
+		}
+
+		var cutoff time.Time
+		if *olderThan != "" {
+			age, err := parseAge(*olderThan)
+			if err != nil {
+				return err
+			}
+			cutoff = time.Now().Add(-age)
+		}
+
+		runs, err := listRuns(*outputDir)
+		if err != nil {
+			return err
+		}
+		for i, run := range runs {
+			if *keep >= 0 && i >= len(runs)-*keep {
+				continue
+			}
+			if !cutoff.IsZero() && run.StartedAt.After(cutoff) {
+				continue
+			}
+			if run.Status == RunStatusRunning && !*force {
+				log.Println("Skipping running run:", run.ID)
+				continue
+			}
+			if *dryRun {
+				fmt.Println("would remove", run.Dir())
+				continue
+			}
+			log.Println("Removing run:", run.ID)
+			if err := os.RemoveAll(run.Dir()); err != nil {
+				return fmt.Errorf("failed to remove run %s: %w", run.ID, err)
+			}
+		}
+		return nil
+
+	default:
+		return errors.New(usage)
+	}
+}
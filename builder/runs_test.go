@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestRun writes a run record with the given status into outputDir
+func writeTestRun(t *testing.T, outputDir, id, status string, started time.Time) string {
+	t.Helper()
+	dir := filepath.Join(outputDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRunMeta(&RunMeta{ID: id, Status: status, StartedAt: started, dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestParseAge(t *testing.T) {
+	for age, want := range map[string]time.Duration{"7d": 7 * 24 * time.Hour, "12h": 12 * time.Hour} {
+		if got, err := parseAge(age); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %v, %v, want %v", age, got, err, want)
+		}
+	}
+	for _, age := range []string{"-7d", "0d", "-1h", "0s", "abc"} {
+		if got, err := parseAge(age); err == nil {
+			t.Errorf("parseAge(%q) = %v, want an error", age, got)
+		}
+	}
+}
+
+func TestListRunsCorrupt(t *testing.T) {
+	outputDir := t.TempDir()
+	writeTestRun(t, outputDir, "run_1.5_1", RunStatusSuccess, time.Now())
+	corrupt := filepath.Join(outputDir, "run_1.5_2")
+	if err := os.MkdirAll(corrupt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(corrupt, RunMetaFile), []byte(`{"id": "run_1.5_2", "sta`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := listRuns(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]string)
+	for _, run := range runs {
+		status[run.ID] = run.Status
+	}
+	if len(runs) != 2 || status["run_1.5_1"] != RunStatusSuccess || status["run_1.5_2"] != "unknown" {
+		t.Errorf("listed runs = %v", status)
+	}
+}
+
+func TestRunsPruneSkipsRunning(t *testing.T) {
+	outputDir := t.TempDir()
+	now := time.Now()
+	finished := writeTestRun(t, outputDir, "run_1.5_1", RunStatusSuccess, now.Add(-time.Hour))
+	running := writeTestRun(t, outputDir, "run_1.5_2", RunStatusRunning, now)
+
+	if err := runsCommand([]string{"prune", "-output", outputDir, "-keep", "0"}); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(finished) {
+		t.Error("finished run was not removed")
+	}
+	if !fileExists(running) {
+		t.Fatal("running run was removed without -force")
+	}
+
+	if err := runsCommand([]string{"prune", "-output", outputDir, "-keep", "0", "-force"}); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(running) {
+		t.Error("running run was not removed with -force")
+	}
+}
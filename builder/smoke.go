@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Policies for the smoke tests of the built binaries
+const (
+	SmokeOff  = "off"  // do not run the binaries
+	SmokeWarn = "warn" // report failures and continue
+	SmokeFail = "fail" // fail the run
+)
+
+// SmokeDir holds the output of every smoke check in a run directory
+const SmokeDir = "smoke"
+
+// DefaultSmokeTimeout bounds each smoke check
+const DefaultSmokeTimeout = 2 * time.Minute
+
+// SmokeReport records the smoke tests of a run's binaries
+type SmokeReport struct {
+	Passed   bool         `json:"passed"`
+	Skipped  string       `json:"skipped,omitempty"`  // why nothing was run
+	Error    string       `json:"error,omitempty"`    // failure not tied to a single check
+	Checks   []SmokeCheck `json:"checks,omitempty"`   // in the order they ran
+	Branding []string     `json:"branding,omitempty"` // replacement names seen in output or files
+}
+
+// SmokeCheck is one run of a built binary
+type SmokeCheck struct {
+	Name     string   `json:"name"`
+	Artifact string   `json:"artifact"`
+	Args     []string `json:"args"`
+	ExitCode int      `json:"exit_code"` // -1 if killed
+	Duration string   `json:"duration"`
+	Passed   bool     `json:"passed"`
+	Error    string   `json:"error,omitempty"`
+	Log      string   `json:"log"` // output, relative to the run directory
+}
+
+// smokeBinaries returns the server and client artifacts that can run on
+// this host, either empty if there is none
+func smokeBinaries(runDir string, artifacts []Artifact) (server, client string) {
+	for _, artifact := range artifacts {
+		name := strings.ToLower(artifact.Name)
+		if !runnable(filepath.Join(runDir, artifact.Path)) {
+			continue
+		}
+		switch {
+		case server == "" && strings.Contains(name, "server"):
+			server = artifact.Path
+		case client == "" && strings.Contains(name, "client"):
+			client = artifact.Path
+		}
+	}
+	return server, client
+}
+
+// runnable reports whether path is an executable file that is not a
+// Windows or macOS binary
+func runnable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	for _, foreign := range [][]byte{
+		[]byte("MZ"),                                       // PE
+		{0xfe, 0xed, 0xfa, 0xce}, {0xfe, 0xed, 0xfa, 0xcf}, // Mach-O
+		{0xce, 0xfa, 0xed, 0xfe}, {0xcf, 0xfa, 0xed, 0xfe},
+		{0xca, 0xfe, 0xba, 0xbe}, // Mach-O universal
+	} {
+		if bytes.HasPrefix(magic, foreign) {
+			return false
+		}
+	}
+	return true
+}
+
+// smoke runs the server with unpack and daemon and the client with version
+// in a temporary HOME. Each check must succeed within timeout, the daemon by
+// accepting connections. Output and files created in HOME must not mention
+// any of the forbidden terms, and some of them must mention one of the
+// replacement names if there are any.
+func smoke(runDir string, artifacts []Artifact, forbidden []string, replacements []Replacement, timeout time.Duration) (*SmokeReport, error) {
+	report := &SmokeReport{}
+	if runtime.GOOS != "linux" {
+		report.Skipped = "smoke tests only run on Linux hosts"
+		return report, nil
+	}
+	server, client := smokeBinaries(runDir, artifacts)
+	if server == "" && client == "" {
+		report.Skipped = "no server or client binary for this host"
+		return report, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(runDir, SmokeDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create smoke directory: %w", err)
+	}
+	home, err := os.MkdirTemp("", "cloak-smoke-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(home)
+	env := setEnv(os.Environ(), "HOME", home)
+
+	var checks []SmokeCheck
+	var daemonAddr string
+	if server != "" {
+		port, err := freePort()
+		if err != nil {
+			return nil, err
+		}
+		daemonAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		checks = append(checks,
+			SmokeCheck{Name: "unpack", Artifact: server, Args: []string{"unpack", "--force"}},
+			SmokeCheck{Name: "daemon", Artifact: server, Args: []string{"daemon", "--lhost", "127.0.0.1", "--lport", strconv.Itoa(port)}},
+		)
+	}
+	if client != "" {
+		checks = append(checks, SmokeCheck{Name: "version", Artifact: client, Args: []string{"version"}})
+	}
+
+	seen := make(map[string]bool)
+	known := homeFiles(home)
+	for _, check := range checks {
+		check.Log = filepath.Join(SmokeDir, check.Name+".log")
+		start := time.Now()
+		if check.Name == "daemon" {
+			err = runDaemon(runDir, &check, env, daemonAddr, timeout)
+		} else {
+			err = runCheck(runDir, &check, env, timeout)
+		}
+		check.Duration = time.Since(start).Round(time.Millisecond).String()
+
+		// Look at what the check printed and the files it created
+		output, rerr := os.ReadFile(filepath.Join(runDir, check.Log))
+		if rerr != nil {
+			return nil, rerr
+		}
+		var created []string
+		for name := range homeFiles(home) {
+			if !known[name] {
+				known[name] = true
+				created = append(created, name)
+			}
+		}
+		text := strings.ToLower(string(output) + "\n" + strings.Join(created, "\n"))
+		if err == nil {
+			for _, term := range forbidden {
+				if term != "" && strings.Contains(text, strings.ToLower(term)) {
+					err = fmt.Errorf("output or files mention %q", term)
+					break
+				}
+			}
+		}
+		for _, r := range replacements {
+			if r.Replace != "" && strings.Contains(text, strings.ToLower(r.Replace)) {
+				seen[r.Replace] = true
+			}
+		}
+
+		check.Passed = err == nil
+		if err != nil {
+			check.Error = err.Error()
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	for name := range seen {
+		report.Branding = append(report.Branding, name)
+	}
+	sort.Strings(report.Branding)
+	report.Passed = true
+	for _, check := range report.Checks {
+		report.Passed = report.Passed && check.Passed
+	}
+	if report.Passed && len(replacements) > 0 && len(report.Branding) == 0 {
+		report.Passed = false
+		report.Error = "no replacement name seen in the output or files of any check"
+	}
+	return report, nil
+}
+
+// runCheck runs a binary to completion and fails on a non-zero exit status
+func runCheck(runDir string, check *SmokeCheck, env []string, timeout time.Duration) error {
+	cmd, logFile, err := smokeCommand(runDir, check, env)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	if err := cmd.Start(); err != nil {
+		check.ExitCode = -1
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		check.ExitCode = -1
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	check.ExitCode = cmd.ProcessState.ExitCode()
+	if err != nil {
+		return fmt.Errorf("exited with %v", err)
+	}
+	return nil
+}
+
+// runDaemon starts the server daemon and waits until it accepts connections
+// on addr. It fails if the daemon exits first.
+func runDaemon(runDir string, check *SmokeCheck, env []string, addr string, timeout time.Duration) error {
+	cmd, logFile, err := smokeCommand(runDir, check, env)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	if err := cmd.Start(); err != nil {
+		check.ExitCode = -1
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-done:
+			check.ExitCode = cmd.ProcessState.ExitCode()
+			if err != nil {
+				return fmt.Errorf("daemon exited with %v", err)
+			}
+			return errors.New("daemon exited before accepting connections")
+		case <-time.After(250 * time.Millisecond):
+		}
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			cmd.Process.Kill()
+			<-done
+			check.ExitCode = -1
+			return nil
+		}
+	}
+	cmd.Process.Kill()
+	<-done
+	check.ExitCode = -1
+	return fmt.Errorf("daemon not listening on %s after %s", addr, timeout)
+}
+
+// smokeCommand prepares a check's command, run in HOME with its output
+// going to the check's log. The output goes to a file rather than a pipe,
+// so processes the binary leaves behind cannot block waiting for it.
+func smokeCommand(runDir string, check *SmokeCheck, env []string) (*exec.Cmd, *os.File, error) {
+	logFile, err := os.Create(filepath.Join(runDir, check.Log))
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd := exec.Command(filepath.Join(runDir, check.Artifact), check.Args...)
+	cmd.Dir = getEnv(env, "HOME")
+	cmd.Env = env
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd, logFile, nil
+}
+
+// freePort returns a TCP port on the loopback interface that is not in use
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// homeFiles returns the paths under home, relative to it
+func homeFiles(home string) map[string]bool {
+	files := make(map[string]bool)
+	filepath.WalkDir(home, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && path != home {
+			rel, _ := filepath.Rel(home, path)
+			files[filepath.ToSlash(rel)] = true
+		}
+		return nil
+	})
+	return files
+}
+
+// flattenRenames returns the replacements of a run's modules in module order
+func flattenRenames(modules []string, renames map[string][]Replacement) []Replacement {
+	var replacements []Replacement
+	for _, name := range modules {
+		replacements = append(replacements, renames[name]...)
+	}
+	return replacements
+}
+
+// smokeTest runs the smoke tests on the run's artifacts, unless disabled
+func (b *Builder) smokeTest(moduleNames []string, artifacts []Artifact) error {
+	if b.config.Smoke == "" || b.config.Smoke == SmokeOff {
+		return nil
+	}
+	timeout := b.config.SmokeTimeout
+	if timeout == 0 {
+		timeout = DefaultSmokeTimeout
+	}
+
+	log.Println("Running smoke tests...")
+	report, err := smoke(b.config.RunDir, artifacts, b.searchTerms(moduleNames), b.config.Renames, timeout)
+	if err != nil {
+		return fmt.Errorf("smoke tests failed to run: %w", err)
+	}
+	b.meta.Smoke = report
+	if err := writeRunMeta(b.meta); err != nil {
+		return err
+	}
+	if report.Skipped != "" {
+		log.Println("Smoke tests skipped:", report.Skipped)
+		return nil
+	}
+	for _, check := range report.Checks {
+		if b.verbose || !check.Passed {
+			log.Printf("Smoke %s: %s", check.Name, checkStatus(check))
+		}
+	}
+	if report.Passed {
+		log.Printf("Smoke tests passed (%d checks)", len(report.Checks))
+		return nil
+	}
+
+	msg := "smoke tests failed: " + smokeFailures(report)
+	if b.config.Smoke == SmokeFail {
+		return errors.New(msg)
+	}
+	log.Println("Warning:", msg)
+	return nil
+}
+
+// checkStatus describes the outcome of a check
+func checkStatus(check SmokeCheck) string {
+	if check.Passed {
+		return "ok"
+	}
+	return "FAIL: " + check.Error
+}
+
+// smokeFailures summarizes what failed in a report
+func smokeFailures(report *SmokeReport) string {
+	var failures []string
+	for _, check := range report.Checks {
+		if !check.Passed {
+			failures = append(failures, check.Name+": "+check.Error)
+		}
+	}
+	if report.Error != "" {
+		failures = append(failures, report.Error)
+	}
+	return strings.Join(failures, "; ")
+}
+
+// writeSmoke prints a report as a table
+func writeSmoke(w io.Writer, report *SmokeReport) {
+	if report.Skipped != "" {
+		fmt.Fprintln(w, "skipped:", report.Skipped)
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tCOMMAND\tEXIT\tDURATION\tRESULT")
+	for _, check := range report.Checks {
+		command := strings.Join(append([]string{filepath.Base(check.Artifact)}, check.Args...), " ")
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", check.Name, command, check.ExitCode, check.Duration, checkStatus(check))
+	}
+	tw.Flush()
+	if len(report.Branding) > 0 {
+		fmt.Fprintln(w, "branding seen:", strings.Join(report.Branding, ", "))
+	}
+	if report.Error != "" {
+		fmt.Fprintln(w, "error:", report.Error)
+	}
+}
+
+// verifySmokeCommand implements 'cloak verify smoke <run>'. It runs the
+// smoke tests on the artifacts of an existing run.
+func verifySmokeCommand(args []string) error {
+	fs := newFlagSet("verify smoke", "[flags] <run>", "Run the server and client binaries of a run in a temporary HOME and check that they start.")
+	outputDir := outputFlag(fs)
+	timeout := fs.Duration("timeout", DefaultSmokeTimeout, "Time limit for each check")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: cloak verify smoke <run>")
+	}
+
+	run, err := findRun(*outputDir, positional[0])
+	if err != nil {
+		return err
+	}
+	var terms []string
+	if run.Residuals != nil {
+		terms = run.Residuals.Terms
+	}
+	report, err := smoke(run.Dir(), run.Artifacts, terms, flattenRenames(run.Modules, run.Renames), *timeout)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		writeSmoke(os.Stdout, report)
+	}
+	if report.Skipped == "" && !report.Passed {
+		return fmt.Errorf("smoke tests of run %s failed", run.ID)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSliverEnv makes the test binary act as a built server or client, see
+// fakeSliver
+const fakeSliverEnv = "CLOAK_FAKE_SLIVER"
+
+func TestMain(m *testing.M) {
+	if behaviour := os.Getenv(fakeSliverEnv); behaviour != "" {
+		os.Exit(fakeSliver(behaviour, os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeSliver mimics the commands the smoke tests run. It is branded as
+// gunner, except that "leak" prints sliver and "crash" has a daemon that
+// exits.
+func fakeSliver(behaviour string, args []string) int {
+	if len(args) == 0 {
+		return 2
+	}
+	switch args[0] {
+	case "unpack":
+		if err := os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".gunner", "go"), 0755); err != nil {
+			return 1
+		}
+		fmt.Println("Unpacking assets ...")
+	case "daemon":
+		if behaviour == "crash" {
+			fmt.Println("panic: failed to start")
+			return 1
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(args[2], args[4]))
+		if err != nil {
+			return 1
+		}
+		defer l.Close()
+		time.Sleep(time.Minute)
+	case "version":
+		if behaviour == "leak" {
+			fmt.Println("sliver v1.5.42")
+		} else {
+			fmt.Println("Client v1.5.42 - linux/amd64")
+		}
+	default:
+		return 2
+	}
+	return 0
+}
+
+// smokeRun returns a run directory whose server and client artifacts are
+// the test binary
+func smokeRun(t *testing.T, behaviour string) (string, []Artifact) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("smoke tests only run on Linux")
+	}
+	t.Setenv(fakeSliverEnv, behaviour)
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(runDir, "artifacts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var artifacts []Artifact
+	for _, name := range []string{"gunner-server", "gunner-client", "gunner-server.exe"} {
+		path := filepath.Join("artifacts", name)
+		if err := os.Symlink(self, filepath.Join(runDir, path)); err != nil {
+			t.Fatal(err)
+		}
+		artifacts = append(artifacts, Artifact{Name: name, Path: path})
+	}
+	return runDir, artifacts
+}
+
+var smokeRenames = []Replacement{{Search: "sliver", Replace: "gunner"}}
+
+func TestSmoke(t *testing.T) {
+	runDir, artifacts := smokeRun(t, "ok")
+	report, err := smoke(runDir, artifacts, []string{"sliver"}, smokeRenames, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed {
+		t.Fatalf("smoke tests failed: %s", smokeFailures(report))
+	}
+	var names []string
+	for _, check := range report.Checks {
+		names = append(names, check.Name)
+		if _, err := os.Stat(filepath.Join(runDir, check.Log)); err != nil {
+			t.Errorf("check %s has no log: %v", check.Name, err)
+		}
+	}
+	if got := strings.Join(names, ","); got != "unpack,daemon,version" {
+		t.Errorf("checks = %s", got)
+	}
+	if len(report.Branding) != 1 || report.Branding[0] != "gunner" {
+		t.Errorf("branding seen = %v, want [gunner]", report.Branding)
+	}
+}
+
+func TestSmokeFailures(t *testing.T) {
+	runDir, artifacts := smokeRun(t, "leak")
+	report, err := smoke(runDir, artifacts, []string{"sliver"}, smokeRenames, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Passed || report.Checks[2].Passed || !strings.Contains(report.Checks[2].Error, "sliver") {
+		t.Errorf("leaked term not reported: %+v", report.Checks[2])
+	}
+
+	runDir, artifacts = smokeRun(t, "crash")
+	report, err = smoke(runDir, artifacts, nil, nil, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if daemon := report.Checks[1]; report.Passed || daemon.Passed || daemon.ExitCode != 1 {
+		t.Errorf("crashing daemon not reported: %+v", daemon)
+	}
+
+	// Without a replacement name anywhere, the branding check fails
+	runDir, artifacts = smokeRun(t, "ok")
+	report, err = smoke(runDir, artifacts, nil, []Replacement{{Search: "sliver", Replace: "lazer"}}, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Passed || report.Error == "" {
+		t.Errorf("missing branding not reported: %+v", report)
+	}
+}
+
+func TestSmokeBinaries(t *testing.T) {
+	runDir := t.TempDir()
+	files := map[string]string{
+		"sliver-server":     "\x7fELF",
+		"sliver-server.exe": "MZ\x90\x00",
+		"sliver-client_mac": "\xcf\xfa\xed\xfe",
+		"notes-client.txt":  "text",
+	}
+	var artifacts []Artifact
+	for name, content := range files {
+		mode := os.FileMode(0755)
+		if strings.HasSuffix(name, ".txt") {
+			mode = 0644
+		}
+		if err := os.WriteFile(filepath.Join(runDir, name), []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+		artifacts = append(artifacts, Artifact{Name: name, Path: name})
+	}
+	server, client := smokeBinaries(runDir, artifacts)
+	if server != "sliver-server" || client != "" {
+		t.Errorf("smokeBinaries = %q, %q", server, client)
+	}
+}
@@ -0,0 +1,6 @@
+package sliver
+
+// BeaconMain runs the implant in beacon mode
+func BeaconMain() {
+	httpSessionInit()
+}
@@ -0,0 +1,10 @@
+package main
+
+import "github.com/knightbruce/gunner/client/console"
+
+// GunnerVersion is printed by the client
+const GunnerVersion = "gunner-client"
+
+func main() {
+	console.Start()
+}
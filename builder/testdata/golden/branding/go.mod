@@ -0,0 +1,3 @@
+module github.com/knightbruce/gunner
+
+go 1.18
@@ -0,0 +1,6 @@
+package gunner
+
+// LazerMain runs the implant in lazer mode
+func LazerMain() {
+	httpSessionInit()
+}
@@ -0,0 +1,3 @@
+#!/bin/sh
+# Builds the Gunner server and client
+make -C .. gunner-server gunner-client
@@ -0,0 +1,15 @@
+package generate
+
+import "github.com/Binject/go-donut/donut"
+
+func donutConfig() *donut.DonutConfig {
+	return &donut.DonutConfig{
+		Type:       donut.DONUT_MODULE_EXE,
+		Bypass:     3,         // 1=skip, 2=abort on fail, 3=continue on fail.
+		Compress:   uint32(1),
+	}
+}
+
+func setBypass(config *donut.DonutConfig) {
+	config.Bypass = 3
+}
@@ -0,0 +1,10 @@
+package main
+
+import "github.com/bishopfox/sliver/client/console"
+
+// SliverVersion is printed by the client
+const SliverVersion = "sliver-client"
+
+func main() {
+	console.Start()
+}
@@ -0,0 +1,3 @@
+module github.com/bishopfox/sliver
+
+go 1.18
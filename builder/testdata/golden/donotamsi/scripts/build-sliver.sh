@@ -0,0 +1,3 @@
+#!/bin/sh
+# Builds the Sliver server and client
+make -C .. sliver-server sliver-client
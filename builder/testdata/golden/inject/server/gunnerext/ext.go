@@ -0,0 +1,9 @@
+package glozesou
+
+// Added by cloak for v1.5.42, engagement ENG-7
+
+import "github.com/knightbruce/gunner/server/core"
+
+func BregapotLocea() string {
+	return core.BregapotLocea("blue")
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// DefaultToolchainDir is where cloak keeps its Go toolchains and protobuf tools
+const DefaultToolchainDir = "/opt/cloak/toolchains"
+
+// Tools managed by the toolchain cache
+const (
+	ToolGo              = "go"
+	ToolProtoc          = "protoc"
+	ToolProtocGenGo     = "protoc-gen-go"
+	ToolProtocGenGoGrpc = "protoc-gen-go-grpc"
+)
+
+// toolNames lists the managed tools in the order they are put on the PATH
+var toolNames = []string{ToolGo, ToolProtoc, ToolProtocGenGo, ToolProtocGenGoGrpc}
+
+// goInstallPaths maps protoc plugins to the package 'go install' builds them from
+var goInstallPaths = map[string]string{
+	ToolProtocGenGo:     "google.golang.org/protobuf/cmd/protoc-gen-go",
+	ToolProtocGenGoGrpc: "google.golang.org/grpc/cmd/protoc-gen-go-grpc",
+}
+
+// Toolchain names the tool versions a target is built with. An empty version
+// means whatever is found on the PATH is used.
+type Toolchain struct {
+	Go              string `json:"go,omitempty"`     // e.g. "1.18.10"
+	Protoc          string `json:"protoc,omitempty"` // e.g. "25.2"
+	ProtocGenGo     string `json:"protoc_gen_go,omitempty"`
+	ProtocGenGoGrpc string `json:"protoc_gen_go_grpc,omitempty"`
+}
+
+// versions returns the pinned tools and their versions
+func (t Toolchain) versions() map[string]string {
+	versions := make(map[string]string)
+	for tool, version := range map[string]string{
+		ToolGo:              t.Go,
+		ToolProtoc:          t.Protoc,
+		ToolProtocGenGo:     t.ProtocGenGo,
+		ToolProtocGenGoGrpc: t.ProtocGenGoGrpc,
+	} {
+		if version != "" {
+			versions[tool] = version
+		}
+	}
+	return versions
+}
+
+// ToolCache manages tool installations side by side in a directory:
+//
+//	<dir>/go/<version>/bin/go
+//	<dir>/protoc/<version>/bin/protoc
+//	<dir>/protoc-gen-go/<version>/bin/protoc-gen-go
+//	<dir>/protoc-gen-go-grpc/<version>/bin/protoc-gen-go-grpc
+//
+// Nothing outside the cache directory is modified; a toolchain is selected
+// by pointing PATH and GOROOT at it in the build environment.
+type ToolCache struct {
+	Dir string
+}
+
+// NewToolCache returns a cache rooted at dir
+func NewToolCache(dir string) *ToolCache {
+	return &ToolCache{Dir: dir}
+}
+
+// toolDir returns the install directory of a tool version
+func (c *ToolCache) toolDir(tool, version string) string {
+	return filepath.Join(c.Dir, tool, normalizeVersion(tool, version))
+}
+
+// binDir returns the directory holding the executables of a tool version
+func (c *ToolCache) binDir(tool, version string) string {
+	return filepath.Join(c.toolDir(tool, version), "bin")
+}
+
+// Installed reports whether a tool version is present in the cache
+func (c *ToolCache) Installed(tool, version string) bool {
+	info, err := os.Stat(filepath.Join(c.binDir(tool, version), tool))
+	return err == nil && !info.IsDir()
+}
+
+// List returns the installed versions of every tool
+func (c *ToolCache) List() (map[string][]string, error) {
+	installed := make(map[string][]string)
+	for _, tool := range toolNames {
+		entries, err := os.ReadDir(filepath.Join(c.Dir, tool))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && c.Installed(tool, entry.Name()) {
+				installed[tool] = append(installed[tool], entry.Name())
+			}
+		}
+	}
+	return installed, nil
+}
+
+// Env returns base with PATH, GOROOT and GOTOOLCHAIN set up to use the tools
+// pinned by tc. Pinned tools that are not in the cache are accepted when the
+// matching version is already on the PATH; otherwise an error explains how to
+// install them.
+func (c *ToolCache) Env(tc Toolchain, base []string) ([]string, error) {
+	env := append([]string(nil), base...)
+	var paths []string
+
+	versions := tc.versions()
+	for _, tool := range toolNames {
+		version, pinned := versions[tool]
+		if !pinned {
+			continue
+		}
+
+		if c.Installed(tool, version) {
+			paths = append(paths, c.binDir(tool, version))
+			if tool == ToolGo {
+				env = setEnv(env, "GOROOT", c.toolDir(tool, version))
+				env = setEnv(env, "GOTOOLCHAIN", "local")
+			}
+			continue
+		}
+
+		current, err := systemToolVersion(tool, base)
+		if err != nil || normalizeVersion(tool, current) != normalizeVersion(tool, version) {
+			return nil, fmt.Errorf("%s %s is not installed (found %q on PATH); run: cloak toolchain install %s %s",
+				tool, version, current, tool, version)
+		}
+	}
+
+	if len(paths) > 0 {
+		paths = append(paths, getEnv(env, "PATH"))
+		env = setEnv(env, "PATH", strings.Join(paths, string(os.PathListSeparator)))
+	}
+	return env, nil
+}
+
+// systemToolVersion returns the version reported by a tool found on the PATH
+// of env
+func systemToolVersion(tool string, env []string) (string, error) {
+	arg := "--version"
+	if tool == ToolGo {
+		arg = "version"
+	}
+
+	path, err := lookPathEnv(tool, env)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(path, arg)
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	// "go version go1.18.10 linux/amd64", "libprotoc 25.2",
+	// "protoc-gen-go v1.31.0", "protoc-gen-go-grpc 1.3.0"
+	fields := strings.Fields(string(output))
+	if tool == ToolGo && len(fields) >= 3 {
+		return fields[2], nil
+	}
+	if len(fields) >= 2 {
+		return fields[1], nil
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// normalizeVersion strips the prefixes tools put in front of their version
+// numbers so "go1.18.10", "v1.18.10" and "1.18.10" compare equal, and maps
+// protoc versions to release names (libprotoc 3.21.12 is release 21.12)
+func normalizeVersion(tool, version string) string {
+	switch tool {
+	case ToolGo:
+		version = strings.TrimPrefix(version, "go")
+	case ToolProtoc:
+		return protocRelease(version)
+	}
+	return strings.TrimPrefix(version, "v")
+}
+
+// lookPathEnv finds an executable on the PATH of env rather than the PATH of
+// the current process
+func lookPathEnv(file string, env []string) (string, error) {
+	for _, dir := range filepath.SplitList(getEnv(env, "PATH")) {
+		path := filepath.Join(dir, file)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found on PATH", file)
+}
+
+// getEnv returns the value of key in env
+func getEnv(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return env[i][len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// setEnv returns env with key set to value, replacing earlier definitions
+func setEnv(env []string, key, value string) []string {
+	out := env[:0:0]
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			out = append(out, kv)
+		}
+	}
+	return append(out, key+"="+value)
+}
+
+// Install adds a tool version to the cache. If from is set it is a local
+// .tar.gz or .zip archive, which allows installing on offline hosts;
+// otherwise the tool is downloaded (go, protoc) or built with 'go install'
+// (protoc plugins).
+func (c *ToolCache) Install(tool, version, from string, verbose bool) error {
+	if c.Installed(tool, version) {
+		log.Printf("%s %s is already installed", tool, version)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(c.Dir, tool), 0755); err != nil {
+		return fmt.Errorf("failed to create toolchain directory: %w", err)
+	}
+
+	// Install into a temporary directory and move it into place once
+	// complete, so an interrupted install never looks installed
+	tmpDir, err := os.MkdirTemp(filepath.Join(c.Dir, tool), ".install-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if from == "" && (tool == ToolProtocGenGo || tool == ToolProtocGenGoGrpc) {
+		err = goInstallTool(tool, version, tmpDir, verbose)
+	} else {
+		err = c.installArchive(tool, version, from, tmpDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to install %s %s: %w", tool, version, err)
+	}
+
+	if !fileExists(filepath.Join(tmpDir, "bin", tool)) {
+		return fmt.Errorf("failed to install %s %s: archive has no bin/%s", tool, version, tool)
+	}
+	if err := os.Rename(tmpDir, c.toolDir(tool, version)); err != nil {
+		return fmt.Errorf("failed to install %s %s: %w", tool, version, err)
+	}
+	log.Printf("Installed %s %s into %s", tool, version, c.toolDir(tool, version))
+	return nil
+}
+
+// installArchive extracts a tool archive (local or downloaded) into dir
+func (c *ToolCache) installArchive(tool, version, from, dir string) error {
+	if from == "" {
+		url, err := downloadURL(tool, version)
+		if err != nil {
+			return err
+		}
+		log.Printf("Downloading %s", url)
+		from = filepath.Join(dir, ".download"+filepath.Ext(url))
+		if err := download(url, from); err != nil {
+			return err
+		}
+		defer os.Remove(from)
+	}
+
+	switch {
+	case tool == ToolGo:
+		// Go archives hold a single top-level "go" directory
+		return extractTarGz(from, dir, 1)
+	case strings.HasSuffix(from, ".zip"):
+		if err := extractZip(from, dir); err != nil {
+			return err
+		}
+	default:
+		if err := extractTarGz(from, dir, 0); err != nil {
+			return err
+		}
+	}
+
+	// Plugin release archives put the binary at the top level
+	if tool != ToolProtoc && !fileExists(filepath.Join(dir, "bin", tool)) && fileExists(filepath.Join(dir, tool)) {
+		if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+			return err
+		}
+		return os.Rename(filepath.Join(dir, tool), filepath.Join(dir, "bin", tool))
+	}
+	return nil
+}
+
+// downloadURL returns the release archive URL of a tool version
+func downloadURL(tool, version string) (string, error) {
+	switch tool {
+	case ToolGo:
+		return fmt.Sprintf("https://go.dev/dl/go%s.%s-%s.tar.gz",
+			normalizeVersion(tool, version), runtime.GOOS, runtime.GOARCH), nil
+	case ToolProtoc:
+		arch := map[string]string{"amd64": "x86_64", "arm64": "aarch_64", "386": "x86_32"}[runtime.GOARCH]
+		if arch == "" || runtime.GOOS != "linux" {
+			return "", fmt.Errorf("no protoc release for %s/%s", runtime.GOOS, runtime.GOARCH)
+		}
+		v := normalizeVersion(tool, version)
+		return fmt.Sprintf("https://github.com/protocolbuffers/protobuf/releases/download/v%s/protoc-%s-linux-%s.zip", v, v, arch), nil
+	default:
+		return "", fmt.Errorf("%s has no release download, omit -from to build it with go install", tool)
+	}
+}
+
+// goInstallTool builds a protoc plugin with the go command on the PATH
+func goInstallTool(tool, version, dir string, verbose bool) error {
+	if !strings.HasPrefix(version, "v") && version != "latest" {
+		version = "v" + version
+	}
+	cmd := exec.Command("go", "install", goInstallPaths[tool]+"@"+version)
+	cmd.Env = setEnv(os.Environ(), "GOBIN", filepath.Join(dir, "bin"))
+	if verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	return cmd.Run()
+}
+
+// download fetches url into path
+func download(url, path string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// safeJoin joins an archive entry name onto dir, stripping the first strip
+// path components and rejecting entries that would escape dir
+func safeJoin(dir, name string, strip int) (string, bool) {
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	if len(parts) <= strip {
+		return "", false
+	}
+	rel := filepath.Clean(filepath.Join(parts[strip:]...))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) || filepath.IsAbs(rel) {
+		return "", false
+	}
+	return filepath.Join(dir, rel), true
+}
+
+// within reports whether path is dir or inside it, without following links
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// extractTarGz extracts a gzipped tar archive into dir
+func extractTarGz(archive, dir string, strip int) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	// Symlinks created so far, nothing is written through them
+	links := make(map[string]bool)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path, ok := safeJoin(dir, hdr.Name, strip)
+		if !ok {
+			continue
+		}
+		for parent := path; parent != dir; parent = filepath.Dir(parent) {
+			if links[parent] {
+				return fmt.Errorf("archive entry %s is inside symlink %s", hdr.Name, parent)
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFileFrom(path, tr, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target := filepath.Join(filepath.Dir(path), hdr.Linkname)
+			if filepath.IsAbs(hdr.Linkname) || !within(dir, target) {
+				return fmt.Errorf("archive symlink %s points outside the archive: %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+			links[path] = true
+		}
+	}
+}
+
+// extractZip extracts a zip archive into dir
+func extractZip(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		path, ok := safeJoin(dir, zf.Name, 0)
+		if !ok {
+			continue
+		}
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		mode := zf.Mode().Perm()
+		if mode == 0 {
+			mode = 0644
+		}
+		err = writeFileFrom(path, rc, mode)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileFrom creates path (and its parents) with the contents of r
+func writeFileFrom(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// toolchainCommand implements 'cloak toolchain list|detect|install'
+func toolchainCommand(args []string) error {
+	usage := "usage: cloak toolchain list | detect <source dir> | install [-from archive] <tool> <version> | install -detect <source dir>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if isHelpFlag(args[0]) {
+		fmt.Fprintf(os.Stderr, "usage: cloak toolchain <command> [flags]\n\ncommands:\n  list     list the toolchains in the cache\n  detect   print the tool versions a source tree needs\n  install  install a tool version into the cache\n")
+		return nil
+	}
+
+	fs := newFlagSet("toolchain "+args[0], "", "Manage Go and protoc toolchains.")
+	dir := fs.String("dir", envOr("CLOAK_TOOLCHAINS", DefaultToolchainDir), "Toolchain cache directory (env CLOAK_TOOLCHAINS)")
+	cache := func() *ToolCache { return NewToolCache(*dir) }
+
+	switch args[0] {
+	case "list":
+		if _, err := parseFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		installed, err := cache().List()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TOOL\tVERSION\tPATH")
+		tools := make([]string, 0, len(installed))
+		for tool := range installed {
+			tools = append(tools, tool)
+		}
+		sort.Strings(tools)
+		for _, tool := range tools {
+			for _, version := range installed[tool] {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", tool, version, cache().toolDir(tool, version))
+			}
+		}
+		return w.Flush()
+
+	case "detect":
+		positional, err := parseFlags(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return errors.New("usage: cloak toolchain detect <source dir>")
+		}
+		req, err := detectRequirements(positional[0])
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(req, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+
+	case "install":
+		from := fs.String("from", "", "Install from a local .tar.gz or .zip archive")
+		detect := fs.String("detect", "", "Install the tools a Sliver source tree was generated with")
+		verbose := fs.Bool("verbose", false, "Show install output")
+		positional, err := parseFlags(fs, args[1:])
+		if err != nil {
+			return err
+		}
+
+		if *detect != "" {
+			req, err := detectRequirements(*detect)
+			if err != nil {
+				return err
+			}
+			if req.ToolchainDirective == "" {
+				log.Printf("go.mod only requires go %s, install a specific release with: cloak toolchain install go <version>", req.GoDirective)
+			}
+			for _, tool := range [][2]string{
+				{ToolGo, req.ToolchainDirective},
+				{ToolProtoc, req.Protoc},
+				{ToolProtocGenGo, req.ProtocGenGo},
+				{ToolProtocGenGoGrpc, req.ProtocGenGoGrpc},
+			} {
+				if tool[1] == "" {
+					continue
+				}
+				if err := cache().Install(tool[0], tool[1], "", *verbose); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if len(positional) != 2 {
+			return errors.New(usage)
+		}
+		tool, version := positional[0], positional[1]
+		known := false
+		for _, name := range toolNames {
+			known = known || name == tool
+		}
+		if !known {
+			return fmt.Errorf("unknown tool %q, expected one of %s", tool, strings.Join(toolNames, ", "))
+		}
+		return cache().Install(tool, version, *from, *verbose)
+
+	default:
+		return errors.New(usage)
+	}
+}
+
+// envOr returns the value of an environment variable, or def when unset
+func envOr(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
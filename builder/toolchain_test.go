@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name, link, body string
+}
+
+// testArchive writes a gzipped tar archive with regular files and symlinks
+func testArchive(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		if e.link != "" {
+			hdr = &tar.Header{Name: e.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: e.link}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	tmp := t.TempDir()
+	archive := filepath.Join(tmp, "go.tar.gz")
+	testArchive(t, archive, []tarEntry{
+		{name: "go/bin/go", body: "go"},
+		{name: "go/bin/gofmt", link: "go"},
+	})
+	dir := filepath.Join(tmp, "out")
+	if err := extractTarGz(archive, dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "bin", "gofmt")); err != nil || string(data) != "go" {
+		t.Errorf("gofmt = %q, %v", data, err)
+	}
+}
+
+func TestExtractTarGzMalicious(t *testing.T) {
+	for name, entries := range map[string][]tarEntry{
+		"absolute link": {{name: "go/escape", link: "/tmp"}},
+		"relative link": {{name: "go/bin/escape", link: "../../.."}},
+		"through link": {
+			{name: "go/lib", link: "bin"},
+			{name: "go/lib/evil", body: "evil"},
+		},
+	} {
+		tmp := t.TempDir()
+		archive := filepath.Join(tmp, "go.tar.gz")
+		testArchive(t, archive, entries)
+		if err := extractTarGz(archive, filepath.Join(tmp, "out"), 1); err == nil {
+			t.Errorf("%s: extracting succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+#!/usr/bin/env python3
+"""Example external cloak module: appends a note to a file in the source tree.
+
+cloak sends one JSON request on stdin and expects one JSON reply on stdout.
+Anything written to stderr is shown as log output.
+"""
+import json
+import os
+import sys
+
+DESCRIPTION = {
+    "description": "Appends a note to a file in the source tree",
+    "params": [
+        {"name": "file", "default": "README.md", "description": "File to append to, relative to the tree"},
+        {"name": "text", "required": True, "description": "Text to append"},
+    ],
+}
+
+
+def run(req):
+    params = req.get("params", {})
+    path = os.path.join(req["source_dir"], params["file"])
+    if not os.path.isfile(path):
+        return {"ok": False, "error": "no such file: " + params["file"]}
+
+    if req.get("verbose"):
+        print("appending to " + path, file=sys.stderr)
+    with open(path, "a") as f:
+        f.write("\n" + params["text"] + "\n")
+    return {"ok": True, "summary": "appended a note to " + params["file"], "changed": [params["file"]]}
+
+
+def main():
+    req = json.load(sys.stdin)
+    if req["action"] == "describe":
+        reply = DESCRIPTION
+    elif req["action"] == "run":
+        reply = run(req)
+    else:
+        reply = {"ok": False, "error": "unsupported action " + req["action"]}
+    json.dump(reply, sys.stdout)
+
+
+if __name__ == "__main__":
+    main()